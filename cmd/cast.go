@@ -1,9 +1,16 @@
 package cmd
 
 import (
+	"github.com/koyeo/cast/cmd/check"
 	"github.com/koyeo/cast/cmd/initialize"
 	"github.com/koyeo/cast/cmd/list"
+	"github.com/koyeo/cast/cmd/rollback"
 	"github.com/koyeo/cast/cmd/run"
+	"github.com/koyeo/cast/cmd/server"
+	"github.com/koyeo/cast/cmd/snapshot"
+	"github.com/koyeo/cast/cmd/unlock"
+	"github.com/koyeo/cast/cmd/verify"
+	"github.com/koyeo/cast/common"
 	"github.com/spf13/cobra"
 	"os"
 )
@@ -18,12 +25,24 @@ Cast - 开发辅助命令行工具集，支持本地构建、服务器上传、
 	},
 }
 
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&common.ConfigFile, "file", "f", "",
+		"config file, defaults to $CAST_FILE or the nearest cast.yaml upward / 配置文件，默认使用 $CAST_FILE 或向上查找最近的 cast.yaml")
+	rootCmd.PersistentFlags().StringArrayVar(&common.Vars, "set", nil,
+		"set a variable KEY=VALUE for ${KEY} in cast.yaml, overriding envs / 设置变量 KEY=VALUE，用于 cast.yaml 中的 ${KEY}，覆盖 envs")
+}
+
 func Execute() {
 	rootCmd.AddCommand(
 		initialize.Cmd,
 		run.Cmd,
 		list.Cmd,
-		//upload.Cmd,
+		rollback.Cmd,
+		snapshot.Cmd,
+		verify.Cmd,
+		unlock.Cmd,
+		check.Cmd,
+		server.Cmd,
 	)
 	err := rootCmd.Execute()
 	if err != nil {
@@ -0,0 +1,42 @@
+package check
+
+import (
+	"fmt"
+	"github.com/gozelle/_color"
+	"github.com/koyeo/cast/common"
+	"github.com/koyeo/cast/logger"
+	"github.com/koyeo/cast/protocol"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var Cmd = &cobra.Command{
+	Use:   "check",
+	Short: "Validate the config file / 校验配置文件",
+	Long: `Validate cast.yaml without running anything: unknown fields, server and task
+references, use cycles, steps and mapper target paths.
+校验 cast.yaml 而不执行任何任务：未知字段、服务器和任务引用、use 循环依赖、步骤及 mapper 目标路径。`,
+	Run: run,
+}
+
+func run(cmd *cobra.Command, args []string) {
+	path, err := protocol.Locate(common.ConfigFile)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+	issues, err := protocol.Check(path)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+	if len(issues) == 0 {
+		fmt.Printf("%s %s is valid\n", _color.GreenString("✔"), path)
+		return
+	}
+	for _, issue := range issues {
+		fmt.Printf("%s:%s\n", issue.File, issue)
+	}
+	fmt.Println(_color.RedString("%d issues found", len(issues)))
+	os.Exit(1)
+}
@@ -4,10 +4,13 @@ import (
 	"fmt"
 	"github.com/gozelle/_fs"
 	"github.com/koyeo/cast/common"
+	infra "github.com/koyeo/cast/deploy/infrastructure"
 	"github.com/spf13/cobra"
 	"strings"
 )
 
+var fromSSHConfig bool
+
 var Cmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize project / 项目初始化",
@@ -15,9 +18,17 @@ var Cmd = &cobra.Command{
 	RunE:  initialize,
 }
 
+func init() {
+	Cmd.Flags().BoolVar(&fromSSHConfig, "from-ssh-config", false,
+		"add the hosts of ~/.ssh/config as servers / 将 ~/.ssh/config 中的主机添加为服务器")
+}
+
 func initialize(cmd *cobra.Command, args []string) (err error) {
 
 	configFile := common.DefaultConfigFile
+	if common.ConfigFile != "" {
+		configFile = common.ConfigFile
+	}
 	l := len(args)
 	if l > 1 {
 		err = fmt.Errorf("at most accept on file")
@@ -31,7 +42,14 @@ func initialize(cmd *cobra.Command, args []string) (err error) {
 		return
 	}
 	if !ok {
-		err = _fs.Write(configFile, []byte(strings.TrimSpace(tpl)))
+		content := tpl
+		if fromSSHConfig {
+			content, err = sshConfigTpl()
+			if err != nil {
+				return
+			}
+		}
+		err = _fs.Write(configFile, []byte(strings.TrimSpace(content)))
 		if err != nil {
 			return
 		}
@@ -93,15 +111,36 @@ tasks:
       - run: echo 'Hi! this is from local!'
 `
 
+// sshConfigTpl returns the template with a server per host of ~/.ssh/config,
+// resolved from it with ssh_config.
+func sshConfigTpl() (string, error) {
+	config, err := infra.LoadSSHConfig("~/.ssh/config")
+	if err != nil {
+		return "", err
+	}
+	hosts := config.Hosts()
+	if len(hosts) == 0 {
+		return "", fmt.Errorf("no host found in ~/.ssh/config")
+	}
+	var servers strings.Builder
+	servers.WriteString("servers:\n")
+	for _, host := range hosts {
+		servers.WriteString(fmt.Sprintf("  %s:\n    ssh_config: %s\n", host, host))
+	}
+	content := tpl[:strings.Index(tpl, "servers:")] + servers.String() + tpl[strings.Index(tpl, "tasks:"):]
+	return strings.ReplaceAll(content, "use: server-1", "use: "+hosts[0]), nil
+}
+
 func injectGitIgnore() (err error) {
 
 	const gitignore = ".gitignore"
+	ignores := []string{common.TmpWorkspace, common.LocalConfigFile}
 	ok, err := _fs.Exists(gitignore)
 	if err != nil {
 		return
 	}
 	if !ok {
-		err = _fs.Write(gitignore, []byte(common.TmpWorkspace))
+		err = _fs.Write(gitignore, []byte(strings.Join(ignores, "\n")))
 		if err != nil {
 			return
 		}
@@ -114,15 +153,18 @@ func injectGitIgnore() (err error) {
 		return
 	}
 	lines := strings.Split(string(content), "\n")
-	exists := false
+	exists := map[string]bool{}
 	for _, line := range lines {
-		if strings.TrimSpace(line) == common.TmpWorkspace {
-			exists = true
-			break
+		exists[strings.TrimSpace(line)] = true
+	}
+	updated := false
+	for _, v := range ignores {
+		if !exists[v] {
+			lines = append(lines, v)
+			updated = true
 		}
 	}
-	if !exists {
-		lines = append(lines, common.TmpWorkspace)
+	if updated {
 		err = _fs.Write(gitignore, []byte(strings.Join(lines, "\n")))
 		if err != nil {
 			return
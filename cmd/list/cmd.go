@@ -7,6 +7,10 @@ import (
 	"github.com/koyeo/cast/logger"
 	"github.com/koyeo/cast/protocol"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -26,7 +30,7 @@ func run(cmd *cobra.Command, args []string) {
 			os.Exit(1)
 		}
 	}()
-	conf, err := protocol.Load(common.DefaultConfigFile)
+	conf, err := protocol.Load(common.ConfigFile)
 	if err != nil {
 		return
 	}
@@ -35,26 +39,78 @@ func run(cmd *cobra.Command, args []string) {
 
 	if len(conf.Tasks) > 0 {
 		fmt.Printf("%s \n", title("tasks:"))
-		for key, task := range conf.Tasks {
-			fmt.Printf("  %-25s %s\n", _color.CyanString(key), _color.WhiteString(task.Comment))
+		for _, key := range sortedKeys(conf.Tasks) {
+			fmt.Printf("  %-25s %-30s %s\n", _color.CyanString(key), _color.WhiteString(conf.Tasks[key].Comment),
+				origin(conf, protocol.OriginTask, key))
+			for _, param := range conf.Tasks[key].Params {
+				if param != nil {
+					fmt.Printf("    %-23s %s\n", _color.WhiteString(param.Name), describeParam(param))
+				}
+			}
 		}
 	}
 	if len(conf.Envs) > 0 {
 		fmt.Printf("%s \n", title("envs:"))
-		for key, value := range conf.Envs {
-			fmt.Printf("  %-25s %s\n", _color.CyanString(key), value)
+		for _, key := range sortedKeys(conf.Envs) {
+			fmt.Printf("  %-25s %-30s %s\n", _color.CyanString(key), conf.Envs[key],
+				origin(conf, protocol.OriginEnv, key))
 		}
 	}
 
 	if len(conf.Servers) > 0 {
 		fmt.Printf("%s \n", title("servers:"))
-		for key, server := range conf.Servers {
-			fmt.Printf("  %-35s %s\n",
-				_color.CyanString(fmt.Sprintf("%s(%s)", key, server.Host)), _color.WhiteString(server.Comment))
+		for _, key := range sortedKeys(conf.Servers) {
+			server := conf.Servers[key]
+			host := server.Host
+			if host == "" {
+				host = "ssh_config:" + server.SSHConfig
+			}
+			fmt.Printf("  %-35s %-20s %s\n",
+				_color.CyanString(fmt.Sprintf("%s(%s)", key, host)), _color.WhiteString(server.Comment),
+				origin(conf, protocol.OriginServer, key))
 		}
 	}
 }
 
+// describeParam returns the comment, default and constraints of a task param.
+func describeParam(param *protocol.Param) string {
+	var items []string
+	if param.Comment != "" {
+		items = append(items, param.Comment)
+	}
+	if param.Required {
+		items = append(items, "required")
+	}
+	if param.Default != "" {
+		items = append(items, "default: "+param.Default)
+	}
+	if len(param.Values) > 0 {
+		items = append(items, "values: "+strings.Join(param.Values, "|"))
+	}
+	return _color.HiBlackString(strings.Join(items, ", "))
+}
+
+// origin returns the file defining a task, env or server,
+// relative to the config file directory.
+func origin(conf *protocol.Config, kind, name string) string {
+	path := conf.Origin(kind, name)
+	if rel, err := filepath.Rel(conf.Dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		path = rel
+	} else if abs, absErr := filepath.Abs(path); absErr == nil {
+		path = abs
+	}
+	return _color.HiBlackString(path)
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func title(s string) string {
 	return _color.New(_color.FgHiGreen, _color.Bold).Sprint(s)
 }
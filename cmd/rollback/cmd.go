@@ -0,0 +1,88 @@
+package rollback
+
+import (
+	"fmt"
+	"github.com/koyeo/cast/common"
+	"github.com/koyeo/cast/logger"
+	"github.com/koyeo/cast/protocol"
+	"github.com/koyeo/cast/runner"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var to string
+
+var Cmd = &cobra.Command{
+	Use:   "rollback <task>",
+	Short: "Rollback deployed files / 回滚部署文件",
+	Long: `Restore the files deployed by a task's mappers to a previous snapshot entry.
+恢复任务部署的文件到之前的 snapshot 记录。`,
+	Run: run,
+}
+
+func init() {
+	Cmd.Flags().StringVar(&to, "to", "", "snapshot entry id to restore, defaults to the previous deploy / 要恢复的记录 ID，默认恢复上一次部署")
+}
+
+func run(cmd *cobra.Command, args []string) {
+	var err error
+	defer func() {
+		if err != nil {
+			logger.Error(err)
+			os.Exit(1)
+		}
+	}()
+	conf, err := protocol.Load(common.ConfigFile)
+	if err != nil {
+		return
+	}
+	conf.Vars, err = protocol.ParseVars(common.Vars)
+	if err != nil {
+		return
+	}
+	if len(args) != 1 {
+		err = fmt.Errorf("rollback accepts exactly 1 task name")
+		return
+	}
+	task, ok := conf.Tasks[args[0]]
+	if !ok {
+		err = fmt.Errorf("task: %s not found", args[0])
+		return
+	}
+	taskRunner := runner.NewTaskRunner(conf, task, args[0])
+	targets, err := taskRunner.Targets()
+	if err != nil {
+		return
+	}
+	if len(targets) == 0 {
+		err = fmt.Errorf("task: %s has no deploy mappers", args[0])
+		return
+	}
+
+	defer runner.CloseConnections()
+	runners := map[string]*runner.ServerRunner{}
+	taskRunner.PrintStart()
+	rolledBack := 0
+	for _, target := range targets {
+		serverRunner, ok := runners[target.Key]
+		if !ok {
+			serverRunner = runner.NewServerRunner(conf, taskRunner, target.Server, target.Key)
+			runners[target.Key] = serverRunner
+		}
+		var done bool
+		done, err = serverRunner.Rollback(target, to)
+		if err != nil {
+			taskRunner.PrintFailed()
+			return
+		}
+		if done {
+			rolledBack++
+		}
+	}
+	if rolledBack == 0 {
+		err = fmt.Errorf("snapshot entry: %s is not a deploy of any mapper of task: %s", to, args[0])
+		taskRunner.PrintFailed()
+		return
+	}
+	taskRunner.PrintSuccess()
+}
@@ -8,14 +8,29 @@ import (
 	"github.com/koyeo/cast/runner"
 	"github.com/spf13/cobra"
 	"os"
+	"time"
+)
+
+var (
+	force        bool
+	lockWait     time.Duration
+	dryRun       bool
+	ignoreBranch bool
 )
 
 var Cmd = &cobra.Command{
-	Use:   "run",
+	Use:   "run <task[:param=value,...]...>",
 	Short: "Run tasks / 执行任务",
 	Run:   run,
 }
 
+func init() {
+	Cmd.Flags().BoolVar(&force, "force", false, "upload even if the same bundle is already deployed / 即使相同 bundle 已部署也重新上传")
+	Cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print what would be run, uploaded and executed without doing it / 只打印将要执行、上传的内容，不实际执行")
+	Cmd.Flags().BoolVar(&ignoreBranch, "ignore-branch", false, "skip the branches, require_clean and require_tag checks of tasks / 跳过任务的 branches、require_clean 和 require_tag 检查")
+	Cmd.Flags().DurationVar(&lockWait, "lock-wait", 0, "how long to wait for a deploy lock held by someone else, e.g. 5m / 目标目录被他人锁定时的等待时长，例如 5m")
+}
+
 func run(cmd *cobra.Command, args []string) {
 	var err error
 	defer func() {
@@ -24,7 +39,12 @@ func run(cmd *cobra.Command, args []string) {
 			os.Exit(1)
 		}
 	}()
-	conf, err := protocol.Load(common.DefaultConfigFile)
+	defer runner.CloseConnections()
+	conf, err := protocol.Load(common.ConfigFile)
+	if err != nil {
+		return
+	}
+	conf.Vars, err = protocol.ParseVars(common.Vars)
 	if err != nil {
 		return
 	}
@@ -33,13 +53,29 @@ func run(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	// one compression per source for every task of the run
+	bundles := runner.NewBundleCache()
+	defer bundles.Remove()
 	for _, v := range args {
-		task, ok := conf.Tasks[v]
+		name, taskArgs, e := protocol.ParseTaskArg(v)
+		if e != nil {
+			err = e
+			return
+		}
+		task, ok := conf.Tasks[name]
 		if !ok {
-			err = fmt.Errorf("task: %s not found", v)
+			err = fmt.Errorf("task: %s not found", name)
 			return
 		}
-		taskRunner := runner.NewTaskRunner(conf, task, v)
+		for k := range taskArgs {
+			if task.Param(k) == nil {
+				err = fmt.Errorf("task: %s has no param: %s", name, k)
+				return
+			}
+		}
+		taskRunner := runner.NewTaskRunner(conf, task, name)
+		taskRunner.SetOptions(runner.Options{Force: force, LockWait: lockWait, DryRun: dryRun, IgnoreBranch: ignoreBranch, Bundles: bundles})
+		taskRunner.SetArgs(taskArgs)
 		taskRunner.PrintStart()
 		err = taskRunner.Exec()
 		if err != nil {
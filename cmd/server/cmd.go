@@ -0,0 +1,269 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gozelle/_color"
+	"github.com/koyeo/cast/common"
+	"github.com/koyeo/cast/logger"
+	"github.com/koyeo/cast/protocol"
+	"github.com/koyeo/cast/runner"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	added    protocol.Server
+	trustYes bool
+)
+
+var Cmd = &cobra.Command{
+	Use:   "server",
+	Short: "Manage the user server inventory / 管理用户服务器清单",
+	Long: `Manage servers kept in ~/.cast/servers.yaml. Any project can reference them
+with deploy.servers[].use, servers of the project taking priority.
+管理 ~/.cast/servers.yaml 中的服务器，任意项目都可以通过 deploy.servers[].use 引用，项目内同名服务器优先。`,
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+	},
+}
+
+var addCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Add or update a server / 添加或更新服务器",
+	Run:   wrap(add),
+}
+
+var removeCmd = &cobra.Command{
+	Use:   "remove <name...>",
+	Short: "Remove servers / 移除服务器",
+	Run:   wrap(remove),
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List servers / 列出服务器",
+	Run:   wrap(list),
+}
+
+var testCmd = &cobra.Command{
+	Use:   "test [name...]",
+	Short: "Test connecting to servers / 测试服务器连接",
+	Run:   wrap(test),
+}
+
+var trustCmd = &cobra.Command{
+	Use:   "trust <name...>",
+	Short: "Trust the current host key of servers / 信任服务器当前的主机密钥",
+	Long: `Fetch the host key of servers of the project or the inventory and record it in
+~/.cast/known_hosts, replacing the key recorded before.
+获取项目或清单中服务器的主机密钥并记录到 ~/.cast/known_hosts，替换之前记录的密钥。`,
+	Run: wrap(trust),
+}
+
+func init() {
+	addCmd.Flags().StringVar(&added.Host, "host", "", "server address / 服务器地址")
+	addCmd.Flags().IntVar(&added.Port, "port", 0, "ssh port, defaults to 22 / SSH 端口，默认 22")
+	addCmd.Flags().StringVar(&added.User, "user", "", "username / 用户名")
+	addCmd.Flags().StringVar(&added.Password, "password", "", "password / 密码")
+	addCmd.Flags().StringVar(&added.IdentityFile, "identity-file", "", "private key file, defaults to the keys of ~/.ssh / 私钥文件，默认使用 ~/.ssh 中的私钥")
+	addCmd.Flags().StringVar(&added.Jump, "jump", "", "server to connect through, e.g. a bastion / 连接时经过的服务器，例如跳板机")
+	addCmd.Flags().StringVar(&added.Proxy, "proxy", "", "socks5:// or http:// proxy url / socks5:// 或 http:// 代理地址")
+	addCmd.Flags().StringVar(&added.SSHConfig, "ssh-config", "", "host alias of ~/.ssh/config, instead of --host / ~/.ssh/config 中的主机别名，可替代 --host")
+	addCmd.Flags().StringVar(&added.Comment, "comment", "", "description / 描述")
+	addCmd.Flags().StringVar(&added.HostKeyPolicy, "host-key-policy", "", "strict, tofu or off, defaults to tofu / 主机密钥策略：strict、tofu 或 off，默认 tofu")
+	addCmd.Flags().StringVar(&added.HostKey, "host-key", "", "pinned host key fingerprint, e.g. SHA256:... / 固定的主机密钥指纹，例如 SHA256:...")
+	trustCmd.Flags().BoolVarP(&trustYes, "yes", "y", false, "trust without asking / 不询问直接信任")
+	Cmd.AddCommand(addCmd, removeCmd, listCmd, testCmd, trustCmd)
+}
+
+func wrap(fn func(args []string) error) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := fn(args); err != nil {
+			logger.Error(err)
+			os.Exit(1)
+		}
+	}
+}
+
+func add(args []string) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("server add accepts exactly 1 server name")
+	}
+	if added.Host == "" && added.SSHConfig == "" {
+		return fmt.Errorf("--host or --ssh-config is required")
+	}
+	inventory, err := protocol.LoadInventory()
+	if err != nil {
+		return
+	}
+	if inventory.Servers == nil {
+		inventory.Servers = map[string]*protocol.Server{}
+	}
+	server := added
+	_, exists := inventory.Servers[args[0]]
+	inventory.Servers[args[0]] = &server
+	if err = inventory.Save(); err != nil {
+		return
+	}
+	if exists {
+		fmt.Printf("update server %s\n", args[0])
+	} else {
+		fmt.Printf("add server %s\n", args[0])
+	}
+	return
+}
+
+func remove(args []string) (err error) {
+	if len(args) == 0 {
+		return fmt.Errorf("miss server name, at least pass 1")
+	}
+	inventory, err := protocol.LoadInventory()
+	if err != nil {
+		return
+	}
+	for _, name := range args {
+		if _, ok := inventory.Servers[name]; !ok {
+			return fmt.Errorf("server: %s not found", name)
+		}
+		delete(inventory.Servers, name)
+	}
+	if err = inventory.Save(); err != nil {
+		return
+	}
+	for _, name := range args {
+		fmt.Printf("remove server %s\n", name)
+	}
+	return
+}
+
+func list(args []string) (err error) {
+	inventory, err := protocol.LoadInventory()
+	if err != nil {
+		return
+	}
+	for _, name := range sortedNames(inventory) {
+		server := inventory.Servers[name]
+		fmt.Printf("  %-35s %s\n",
+			_color.CyanString(fmt.Sprintf("%s(%s)", name, address(server))), _color.WhiteString(server.Comment))
+	}
+	return
+}
+
+func test(args []string) (err error) {
+	inventory, err := protocol.LoadInventory()
+	if err != nil {
+		return
+	}
+	names := args
+	if len(names) == 0 {
+		names = sortedNames(inventory)
+	}
+	defer runner.CloseConnections()
+	failed := 0
+	for _, name := range names {
+		server, ok := inventory.Servers[name]
+		if !ok {
+			return fmt.Errorf("server: %s not found", name)
+		}
+		start := time.Now()
+		// jumps refer to other servers of the inventory
+		serverRunner := runner.NewServerRunner(&protocol.Config{Servers: inventory.Servers}, nil, server, name)
+		pingErr := serverRunner.Ping()
+		if pingErr != nil {
+			failed++
+			fmt.Printf("  %-35s %s\n", _color.CyanString(name), _color.RedString("%s", pingErr))
+			continue
+		}
+		fmt.Printf("  %-35s %s\n", _color.CyanString(name),
+			_color.GreenString("ok (%s)", time.Since(start).Round(time.Millisecond)))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d/%d servers unreachable", failed, len(names))
+	}
+	return
+}
+
+func trust(args []string) (err error) {
+	if len(args) == 0 {
+		return fmt.Errorf("miss server name, at least pass 1")
+	}
+	servers, err := knownServers()
+	if err != nil {
+		return
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for _, name := range args {
+		server, ok := servers[name]
+		if !ok {
+			return fmt.Errorf("server: %s not found", name)
+		}
+		serverRunner := runner.NewServerRunner(&protocol.Config{Servers: servers}, nil, server, name)
+		key, keyErr := serverRunner.HostKey()
+		if keyErr != nil {
+			return keyErr
+		}
+		fingerprint := ssh.FingerprintSHA256(key)
+		fmt.Printf("  %-35s %s %s\n", _color.CyanString(fmt.Sprintf("%s(%s)", name, address(server))), key.Type(), fingerprint)
+		if server.HostKey != "" && server.HostKey != fingerprint && server.HostKey != strings.TrimPrefix(fingerprint, "SHA256:") {
+			return fmt.Errorf("server: %s host key doesn't match its host_key: %s", name, server.HostKey)
+		}
+		if !trustYes {
+			fmt.Print("  trust this host key? [y/N] ")
+			line, _ := reader.ReadString('\n')
+			if answer := strings.ToLower(strings.TrimSpace(line)); answer != "y" && answer != "yes" {
+				fmt.Printf("  skip %s\n", name)
+				continue
+			}
+		}
+		if err = serverRunner.TrustHostKey(key); err != nil {
+			return
+		}
+		fmt.Printf("  trust %s\n", name)
+	}
+	return
+}
+
+// knownServers returns the servers of the project, the inventory ones
+// included, or only the inventory ones outside of a project.
+func knownServers() (map[string]*protocol.Server, error) {
+	if _, err := protocol.Locate(common.ConfigFile); err != nil {
+		inventory, err := protocol.LoadInventory()
+		if err != nil {
+			return nil, err
+		}
+		return inventory.Servers, nil
+	}
+	conf, err := protocol.Load(common.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	return conf.Servers, nil
+}
+
+func address(server *protocol.Server) string {
+	host := server.Host
+	if host == "" {
+		host = "ssh_config:" + server.SSHConfig
+	}
+	if server.User != "" {
+		host = server.User + "@" + host
+	}
+	if server.Port != 0 {
+		host = fmt.Sprintf("%s:%d", host, server.Port)
+	}
+	return host
+}
+
+func sortedNames(inventory *protocol.Inventory) []string {
+	names := make([]string, 0, len(inventory.Servers))
+	for name := range inventory.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
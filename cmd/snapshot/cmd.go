@@ -0,0 +1,295 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gozelle/_color"
+	"github.com/koyeo/cast/common"
+	"github.com/koyeo/cast/deploy/domain"
+	"github.com/koyeo/cast/logger"
+	"github.com/koyeo/cast/protocol"
+	"github.com/koyeo/cast/runner"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var asJSON bool
+
+var Cmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Inspect deploy history on servers / 查看服务器部署历史",
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+	},
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list <task>",
+	Short: "List snapshot entries of a task's targets / 列出任务部署目标的 snapshot 记录",
+	Run:   wrap(list),
+}
+
+var showCmd = &cobra.Command{
+	Use:   "show <task> <entry>",
+	Short: "Show files of a snapshot entry / 显示 snapshot 记录的文件",
+	Run:   wrap(show),
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <task> <from> <to>",
+	Short: "Diff files between two snapshot entries / 对比两条 snapshot 记录的文件",
+	Run:   wrap(diff),
+}
+
+func init() {
+	Cmd.PersistentFlags().BoolVar(&asJSON, "json", false, "print JSON output / 输出 JSON")
+	Cmd.AddCommand(listCmd, showCmd, diffCmd)
+}
+
+func wrap(fn func(args []string) error) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := fn(args); err != nil {
+			logger.Error(err)
+			os.Exit(1)
+		}
+	}
+}
+
+// targetSnapshot is the snapshot of one deploy directory on one server.
+type targetSnapshot struct {
+	Server   string           `json:"server"`
+	Host     string           `json:"host"`
+	Dir      string           `json:"dir"`
+	Snapshot *domain.Snapshot `json:"-"`
+}
+
+type listItem struct {
+	*targetSnapshot
+	Entries []domain.SnapshotEntry `json:"entries"`
+}
+
+type showItem struct {
+	*targetSnapshot
+	Entry domain.SnapshotEntry `json:"entry"`
+}
+
+type diffItem struct {
+	*targetSnapshot
+	From string           `json:"from"`
+	To   string           `json:"to"`
+	Diff domain.EntryDiff `json:"diff"`
+}
+
+// readSnapshots reads the snapshot of every distinct directory the task deploys to.
+func readSnapshots(taskName string) (items []*targetSnapshot, err error) {
+	conf, err := protocol.Load(common.ConfigFile)
+	if err != nil {
+		return
+	}
+	conf.Vars, err = protocol.ParseVars(common.Vars)
+	if err != nil {
+		return
+	}
+	task, ok := conf.Tasks[taskName]
+	if !ok {
+		err = fmt.Errorf("task: %s not found", taskName)
+		return
+	}
+	taskRunner := runner.NewTaskRunner(conf, task, taskName)
+	targets, err := taskRunner.Targets()
+	if err != nil {
+		return
+	}
+	defer runner.CloseConnections()
+	runners := map[string]*runner.ServerRunner{}
+	seen := map[string]bool{}
+	for _, target := range targets {
+		id := target.Key + ":" + target.Dir
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		serverRunner, ok := runners[target.Key]
+		if !ok {
+			serverRunner = runner.NewServerRunner(conf, taskRunner, target.Server, target.Key)
+			runners[target.Key] = serverRunner
+		}
+		var snap *domain.Snapshot
+		snap, err = serverRunner.ReadSnapshot(target.Dir)
+		if err != nil {
+			err = fmt.Errorf("[%s] %s: %s", target.Server.Name(), target.Dir, err)
+			return
+		}
+		items = append(items, &targetSnapshot{
+			Server:   target.Server.Name(),
+			Host:     target.Server.Host,
+			Dir:      target.Dir,
+			Snapshot: snap,
+		})
+	}
+	return
+}
+
+// entries returns the snapshot entries with their IDs filled in.
+func entries(snap *domain.Snapshot) []domain.SnapshotEntry {
+	if snap == nil {
+		return []domain.SnapshotEntry{}
+	}
+	result := make([]domain.SnapshotEntry, len(snap.Entries))
+	for i, entry := range snap.Entries {
+		entry.ID = snap.EntryID(i)
+		result[i] = entry
+	}
+	return result
+}
+
+func list(args []string) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("snapshot list accepts exactly 1 task name")
+	}
+	items, err := readSnapshots(args[0])
+	if err != nil {
+		return
+	}
+	result := make([]*listItem, 0, len(items))
+	for _, item := range items {
+		result = append(result, &listItem{targetSnapshot: item, Entries: entries(item.Snapshot)})
+	}
+	if asJSON {
+		return printJSON(result)
+	}
+	for _, item := range result {
+		printTarget(item.targetSnapshot)
+		if len(item.Entries) == 0 {
+			fmt.Println("  (no deploys)")
+			continue
+		}
+		for _, entry := range item.Entries {
+			printEntry(entry)
+		}
+	}
+	return
+}
+
+func show(args []string) (err error) {
+	if len(args) != 2 {
+		return fmt.Errorf("snapshot show accepts a task name and an entry id")
+	}
+	items, err := readSnapshots(args[0])
+	if err != nil {
+		return
+	}
+	result := make([]*showItem, 0, len(items))
+	for _, item := range items {
+		i := item.Snapshot.FindEntry(args[1])
+		if i < 0 {
+			continue
+		}
+		result = append(result, &showItem{targetSnapshot: item, Entry: entries(item.Snapshot)[i]})
+	}
+	if len(result) == 0 {
+		return fmt.Errorf("snapshot entry: %s not found", args[1])
+	}
+	if asJSON {
+		return printJSON(result)
+	}
+	for _, item := range result {
+		printTarget(item.targetSnapshot)
+		printEntry(item.Entry)
+		for _, f := range item.Entry.Files {
+			fmt.Printf("    %-40s %s  %s\n", f.Path, recordHash(f), f.ModTime.Local().Format("2006-01-02 15:04:05"))
+		}
+	}
+	return
+}
+
+func diff(args []string) (err error) {
+	if len(args) != 3 {
+		return fmt.Errorf("snapshot diff accepts a task name and two entry ids")
+	}
+	items, err := readSnapshots(args[0])
+	if err != nil {
+		return
+	}
+	result := make([]*diffItem, 0, len(items))
+	for _, item := range items {
+		from, to := item.Snapshot.FindEntry(args[1]), item.Snapshot.FindEntry(args[2])
+		if from < 0 || to < 0 {
+			continue
+		}
+		result = append(result, &diffItem{
+			targetSnapshot: item,
+			From:           args[1],
+			To:             args[2],
+			Diff:           domain.DiffEntries(item.Snapshot.Entries[from], item.Snapshot.Entries[to]),
+		})
+	}
+	if len(result) == 0 {
+		return fmt.Errorf("snapshot entries: %s and %s not found in the same target", args[1], args[2])
+	}
+	if asJSON {
+		return printJSON(result)
+	}
+	for _, item := range result {
+		printTarget(item.targetSnapshot)
+		for _, f := range item.Diff.Added {
+			fmt.Printf("  %s %s\n", _color.GreenString("+"), f)
+		}
+		for _, f := range item.Diff.Removed {
+			fmt.Printf("  %s %s\n", _color.RedString("-"), f)
+		}
+		for _, f := range item.Diff.Changed {
+			fmt.Printf("  %s %s\n", _color.YellowString("~"), f)
+		}
+		if len(item.Diff.Added)+len(item.Diff.Removed)+len(item.Diff.Changed) == 0 {
+			fmt.Println("  (no changes)")
+		}
+	}
+	return
+}
+
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal json error: %s", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func printTarget(item *targetSnapshot) {
+	fmt.Printf("%s %s\n",
+		_color.New(_color.FgCyan).Sprintf("[%s]", item.Server),
+		_color.New(_color.FgHiGreen, _color.Bold).Sprint(item.Dir),
+	)
+}
+
+func printEntry(entry domain.SnapshotEntry) {
+	bundle := entry.BundleName
+	if entry.RollbackOf != "" {
+		bundle = fmt.Sprintf("%s (rollback of %s)", bundle, entry.RollbackOf)
+	}
+	fmt.Printf("  %-5s %s  %-30s %s  %d files\n",
+		_color.CyanString(entry.ID),
+		entry.DeployedAt.Local().Format("2006-01-02 15:04:05"),
+		bundle,
+		shortHash(entry.BundleHash),
+		len(entry.Files),
+	)
+}
+
+// recordHash describes the hash of a file record, combining the hashes
+// of the files of a directory.
+func recordHash(f domain.FileRecord) string {
+	if f.Tree == nil {
+		return shortHash(f.Hash)
+	}
+	return fmt.Sprintf("%s (%d files)", shortHash(f.TreeHash()), len(f.Tree))
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -0,0 +1,90 @@
+package unlock
+
+import (
+	"fmt"
+	"github.com/gozelle/_color"
+	"github.com/koyeo/cast/common"
+	"github.com/koyeo/cast/logger"
+	"github.com/koyeo/cast/protocol"
+	"github.com/koyeo/cast/runner"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+var olderThan time.Duration
+
+var Cmd = &cobra.Command{
+	Use:   "unlock <task>",
+	Short: "Remove stale deploy locks / 移除过期的部署锁",
+	Long: `Remove the deploy locks left on a task's targets by interrupted deploys.
+移除被中断的部署遗留在任务部署目标上的部署锁。`,
+	Run: run,
+}
+
+func init() {
+	Cmd.Flags().DurationVar(&olderThan, "older-than", time.Hour, "only remove locks held longer than this, 0 removes any lock / 只移除持有超过该时长的锁，0 表示移除所有锁")
+}
+
+func run(cmd *cobra.Command, args []string) {
+	var err error
+	defer func() {
+		if err != nil {
+			logger.Error(err)
+			os.Exit(1)
+		}
+	}()
+	conf, err := protocol.Load(common.ConfigFile)
+	if err != nil {
+		return
+	}
+	conf.Vars, err = protocol.ParseVars(common.Vars)
+	if err != nil {
+		return
+	}
+	if len(args) != 1 {
+		err = fmt.Errorf("unlock accepts exactly 1 task name")
+		return
+	}
+	task, ok := conf.Tasks[args[0]]
+	if !ok {
+		err = fmt.Errorf("task: %s not found", args[0])
+		return
+	}
+	taskRunner := runner.NewTaskRunner(conf, task, args[0])
+	targets, err := taskRunner.Targets()
+	if err != nil {
+		return
+	}
+
+	defer runner.CloseConnections()
+	runners := map[string]*runner.ServerRunner{}
+	seen := map[string]bool{}
+	for _, target := range targets {
+		id := target.Key + ":" + target.Dir
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		serverRunner, ok := runners[target.Key]
+		if !ok {
+			serverRunner = runner.NewServerRunner(conf, taskRunner, target.Server, target.Key)
+			runners[target.Key] = serverRunner
+		}
+		lock, removed, unlockErr := serverRunner.Unlock(target, olderThan)
+		if unlockErr != nil {
+			err = fmt.Errorf("[%s] %s: %s", target.Server.Name(), target.Dir, unlockErr)
+			return
+		}
+		prefix := fmt.Sprintf("%s %s", _color.New(_color.FgCyan).Sprintf("[%s]", target.Server.Name()), target.Dir)
+		switch {
+		case lock == nil:
+			fmt.Printf("%s: not locked\n", prefix)
+		case removed:
+			fmt.Printf("%s: %s lock of %s\n", prefix, _color.GreenString("removed"), lock)
+		default:
+			fmt.Printf("%s: %s lock of %s, held for %s < --older-than %s\n", prefix, _color.YellowString("kept"),
+				lock, lock.Age(time.Now()).Round(time.Second), olderThan)
+		}
+	}
+}
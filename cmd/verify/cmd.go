@@ -0,0 +1,100 @@
+package verify
+
+import (
+	"fmt"
+	"github.com/gozelle/_color"
+	"github.com/koyeo/cast/common"
+	"github.com/koyeo/cast/deploy/domain"
+	"github.com/koyeo/cast/logger"
+	"github.com/koyeo/cast/protocol"
+	"github.com/koyeo/cast/runner"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var Cmd = &cobra.Command{
+	Use:   "verify <task>",
+	Short: "Detect drift of deployed files / 检测已部署文件是否被改动",
+	Long: `Compare files on the servers against the latest snapshot entry of each mapper,
+reporting files modified, deleted or added by hand. Exits with status 1 on drift.
+对比服务器文件与每个 mapper 最近一次 snapshot 记录，报告被修改、删除或手动新增的文件，存在差异时退出码为 1。`,
+	Run: run,
+}
+
+func run(cmd *cobra.Command, args []string) {
+	var err error
+	defer func() {
+		if err != nil {
+			logger.Error(err)
+			os.Exit(1)
+		}
+	}()
+	conf, err := protocol.Load(common.ConfigFile)
+	if err != nil {
+		return
+	}
+	conf.Vars, err = protocol.ParseVars(common.Vars)
+	if err != nil {
+		return
+	}
+	if len(args) != 1 {
+		err = fmt.Errorf("verify accepts exactly 1 task name")
+		return
+	}
+	task, ok := conf.Tasks[args[0]]
+	if !ok {
+		err = fmt.Errorf("task: %s not found", args[0])
+		return
+	}
+	taskRunner := runner.NewTaskRunner(conf, task, args[0])
+	targets, err := taskRunner.Targets()
+	if err != nil {
+		return
+	}
+
+	defer runner.CloseConnections()
+	runners := map[string]*runner.ServerRunner{}
+	drifted := 0
+	for _, target := range targets {
+		serverRunner, ok := runners[target.Key]
+		if !ok {
+			serverRunner = runner.NewServerRunner(conf, taskRunner, target.Server, target.Key)
+			runners[target.Key] = serverRunner
+		}
+		var report *domain.DriftReport
+		report, err = serverRunner.Verify(target)
+		if err != nil {
+			err = fmt.Errorf("[%s] %s: %s", target.Server.Name(), target.Mapper.Target, err)
+			return
+		}
+		printReport(target, report)
+		if report.HasDrift() {
+			drifted++
+		}
+	}
+	if drifted > 0 {
+		err = fmt.Errorf("drift detected on %d/%d targets", drifted, len(targets))
+		return
+	}
+}
+
+func printReport(target *runner.Target, report *domain.DriftReport) {
+	status := _color.New(_color.FgHiGreen).Sprint("ok")
+	if report.HasDrift() {
+		status = _color.New(_color.FgHiRed).Sprint("drift")
+	}
+	fmt.Printf("%s %s %s\n",
+		_color.New(_color.FgCyan).Sprintf("[%s]", target.Server.Name()),
+		_color.New(_color.FgMagenta, _color.Bold).Sprint(target.Mapper.Target),
+		status,
+	)
+	for _, f := range report.Modified {
+		fmt.Printf("  %s %s\n", _color.YellowString("modified:"), f)
+	}
+	for _, f := range report.Deleted {
+		fmt.Printf("  %s %s\n", _color.RedString("deleted: "), f)
+	}
+	for _, f := range report.Added {
+		fmt.Printf("  %s %s\n", _color.GreenString("added:   "), f)
+	}
+}
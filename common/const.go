@@ -2,5 +2,15 @@ package common
 
 const (
 	DefaultConfigFile = "cast.yaml"
+	LocalConfigFile   = "cast.local.yaml"
 	TmpWorkspace      = ".cast"
+	// ConfigFileEnv names the config file when -f/--file is not passed.
+	ConfigFileEnv = "CAST_FILE"
 )
+
+// ConfigFile is the config file passed with -f/--file,
+// empty to use $CAST_FILE or search upward for cast.yaml.
+var ConfigFile string
+
+// Vars are the KEY=VALUE pairs passed with --set.
+var Vars []string
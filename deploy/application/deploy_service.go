@@ -2,6 +2,8 @@ package application
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"time"
 
 	"github.com/koyeo/cast/deploy/domain"
@@ -15,6 +17,7 @@ type DeployService struct {
 	snapshot domain.SnapshotRepository
 	prompter domain.UserPrompter
 	lang     string
+	out      io.Writer
 }
 
 // NewDeployService creates a new DeployService with all dependencies injected.
@@ -31,9 +34,15 @@ func NewDeployService(
 		snapshot: snapshot,
 		prompter: prompter,
 		lang:     lang,
+		out:      os.Stdout,
 	}
 }
 
+// SetOutput redirects progress messages, e.g. to a per-server prefixed writer.
+func (s *DeployService) SetOutput(out io.Writer) {
+	s.out = out
+}
+
 // Deploy handles extracting, conflict resolution, file deployment, and snapshot update.
 //
 // Parameters:
@@ -41,7 +50,9 @@ func NewDeployService(
 //   - targetDir: the target directory where files should be deployed
 //   - bundleName: name of the bundle (e.g., "app.tar.gz")
 //   - bundleHash: SHA256 hash of the bundle
-func (s *DeployService) Deploy(bundleRemotePath, targetDir, bundleName, bundleHash string) error {
+//   - keep: number of deploys of the bundle whose replaced files are kept
+//     in history for rollbacks, older ones are pruned (default 5)
+func (s *DeployService) Deploy(bundleRemotePath, targetDir, bundleName, bundleHash string, keep int) error {
 	castDir := fmt.Sprintf("%s/.cast", targetDir)
 	tmpDir := fmt.Sprintf("%s/.cast/tmp", targetDir)
 
@@ -99,17 +110,11 @@ func (s *DeployService) Deploy(bundleRemotePath, targetDir, bundleName, bundleHa
 	// Build file records for snapshot
 	var fileRecords []domain.FileRecord
 	for _, f := range extractedFiles {
-		filePath := fmt.Sprintf("%s/%s", targetDir, f)
-		hash, _ := s.fs.FileHash(filePath)
-		modTime, mtErr := s.fs.FileModTime(filePath)
-		if mtErr != nil {
-			modTime = time.Now().UTC()
+		record, err := recordFile(s.fs, targetDir, f)
+		if err != nil {
+			return err
 		}
-		fileRecords = append(fileRecords, domain.FileRecord{
-			Path:    f,
-			Hash:    hash,
-			ModTime: modTime,
-		})
+		fileRecords = append(fileRecords, record)
 	}
 
 	// Update snapshot
@@ -117,28 +122,66 @@ func (s *DeployService) Deploy(bundleRemotePath, targetDir, bundleName, bundleHa
 	isNew := snap == nil
 	if isNew {
 		snap = &domain.Snapshot{}
-		fmt.Println(i18n.Msg(i18n.MsgSnapshotCreated, s.lang))
+		fmt.Fprintln(s.out, i18n.Msg(i18n.MsgSnapshotCreated, s.lang))
 	} else {
-		fmt.Println(i18n.Msg(i18n.MsgSnapshotUpdated, s.lang))
+		fmt.Fprintln(s.out, i18n.Msg(i18n.MsgSnapshotUpdated, s.lang))
 	}
 	snap.AddEntry(entry)
+	if err = pruneHistory(s.fs, s.out, s.lang, targetDir, snap, bundleName, keep); err != nil {
+		return err
+	}
 
 	if err = s.snapshot.Write(targetDir, snap); err != nil {
 		return fmt.Errorf("write snapshot error: %s", err)
 	}
 
-	fmt.Println(i18n.Msg(i18n.MsgDeployComplete, s.lang))
+	fmt.Fprintln(s.out, i18n.Msg(i18n.MsgDeployComplete, s.lang))
 	return nil
 }
 
+// Unchanged reports whether the latest entry of bundleName in targetDir was
+// deployed from a bundle with the same hash and its files are still intact,
+// in which case the upload can be skipped.
+func (s *DeployService) Unchanged(targetDir, bundleName, bundleHash string) (bool, error) {
+	snap, err := s.snapshot.Read(targetDir)
+	if err != nil {
+		return false, fmt.Errorf("read snapshot error: %s", err)
+	}
+	latest := snap.LatestEntry(bundleName)
+	if latest < 0 || snap.Entries[latest].BundleHash != bundleHash {
+		return false, nil
+	}
+	report, err := NewVerifyService(s.fs, s.snapshot).Verify(targetDir, bundleName)
+	if err != nil {
+		return false, err
+	}
+	return len(report.Modified) == 0 && len(report.Deleted) == 0, nil
+}
+
+// Conflicts reports which of files already exist in targetDir, classified
+// the way Deploy would handle them, without changing anything.
+func (s *DeployService) Conflicts(targetDir string, files []string) (domain.ConflictResult, error) {
+	var conflictFiles []string
+	for _, f := range files {
+		if _, statErr := s.fs.Stat(fmt.Sprintf("%s/%s", targetDir, f)); statErr == nil {
+			conflictFiles = append(conflictFiles, f)
+		}
+	}
+	snap, err := s.snapshot.Read(targetDir)
+	if err != nil {
+		return domain.ConflictResult{}, fmt.Errorf("read snapshot error: %s", err)
+	}
+	return domain.ClassifyConflicts(conflictFiles, snap), nil
+}
+
 // resolveConflicts handles managed and unmanaged file conflicts.
 func (s *DeployService) resolveConflicts(targetDir string, conflictFiles []string, snap *domain.Snapshot) error {
 	result := domain.ClassifyConflicts(conflictFiles, snap)
 
-	// Remove Cast-managed files silently
+	// Move Cast-managed files into .cast/history/ so they can be rolled back
 	for _, f := range result.ManagedFiles {
-		if err := s.fs.Remove(fmt.Sprintf("%s/%s", targetDir, f)); err != nil {
-			return fmt.Errorf("remove managed file error: %s", err)
+		if err := archiveFile(s.fs, targetDir, snap.Owner(f), f); err != nil {
+			return fmt.Errorf("archive managed file error: %s", err)
 		}
 	}
 
@@ -157,12 +200,12 @@ func (s *DeployService) resolveConflicts(targetDir string, conflictFiles []strin
 					_, statErr := s.fs.Stat(fmt.Sprintf("%s/%s", targetDir, candidate))
 					return statErr == nil
 				})
-				fmt.Println(i18n.Msgf(i18n.MsgBackingUp, s.lang, f, backupName))
+				fmt.Fprintln(s.out, i18n.Msgf(i18n.MsgBackingUp, s.lang, f, backupName))
 				if err = s.fs.Rename(filePath, fmt.Sprintf("%s/%s", targetDir, backupName)); err != nil {
 					return fmt.Errorf("backup file error: %s", err)
 				}
 			case domain.ActionRemove:
-				fmt.Println(i18n.Msgf(i18n.MsgRemoving, s.lang, f))
+				fmt.Fprintln(s.out, i18n.Msgf(i18n.MsgRemoving, s.lang, f))
 				if err = s.fs.Remove(filePath); err != nil {
 					return fmt.Errorf("remove file error: %s", err)
 				}
@@ -172,3 +215,65 @@ func (s *DeployService) resolveConflicts(targetDir string, conflictFiles []strin
 
 	return nil
 }
+
+// historyDir returns where files replaced after the given entry are kept.
+func historyDir(targetDir, entryID string) string {
+	return fmt.Sprintf("%s/.cast/history/%s", targetDir, entryID)
+}
+
+// pruneHistory removes the history of the deploys of bundleName beyond
+// the newest keep ones, along with their entries, so that no rollback
+// can point at them.
+func pruneHistory(fs domain.RemoteFS, out io.Writer, lang, targetDir string, snap *domain.Snapshot, bundleName string, keep int) error {
+	prune := snap.EntriesToPrune(bundleName, keep)
+	for _, i := range prune {
+		dir := historyDir(targetDir, snap.EntryID(i))
+		if _, err := fs.Stat(dir); err != nil {
+			continue
+		}
+		fmt.Fprintln(out, i18n.Msgf(i18n.MsgRemoving, lang, fmt.Sprintf(".cast/history/%s", snap.EntryID(i))))
+		if err := fs.Remove(dir); err != nil {
+			return fmt.Errorf("remove history error: %s", err)
+		}
+	}
+	snap.RemoveEntries(prune)
+	return nil
+}
+
+// recordFile returns the snapshot record of file f in dir: the hash of a
+// file, or the hashes of every file under a directory.
+func recordFile(fs domain.RemoteFS, dir, f string) (domain.FileRecord, error) {
+	filePath := fmt.Sprintf("%s/%s", dir, f)
+	record := domain.FileRecord{Path: f}
+	var err error
+	if info, statErr := fs.Stat(filePath); statErr == nil && info.IsDir() {
+		if record.Tree, err = fs.TreeHashes(filePath); err != nil {
+			return record, fmt.Errorf("hash %s error: %s", filePath, err)
+		}
+		if record.Tree == nil {
+			record.Tree = map[string]string{}
+		}
+	} else if record.Hash, err = fs.FileHash(filePath); err != nil {
+		return record, fmt.Errorf("hash %s error: %s", filePath, err)
+	}
+	modTime, err := fs.FileModTime(filePath)
+	if err != nil {
+		modTime = time.Now().UTC()
+	}
+	record.ModTime = modTime
+	return record, nil
+}
+
+// archiveFile moves the live copy of f, owned by entry entryID, into the
+// history directory, replacing any copy previously archived there.
+func archiveFile(fs domain.RemoteFS, targetDir, entryID, f string) error {
+	dir := historyDir(targetDir, entryID)
+	if err := fs.MkdirAll(dir); err != nil {
+		return err
+	}
+	dst := fmt.Sprintf("%s/%s", dir, f)
+	if err := fs.Remove(dst); err != nil {
+		return err
+	}
+	return fs.Rename(fmt.Sprintf("%s/%s", targetDir, f), dst)
+}
@@ -14,6 +14,8 @@ import (
 type mockRemoteFS struct {
 	files map[string][]byte // path → content
 	dirs  map[string]bool   // directories
+	// replaced records the Replace calls as "src -> dst"
+	replaced []string
 }
 
 func newMockFS() *mockRemoteFS {
@@ -88,6 +90,14 @@ func (m *mockRemoteFS) WriteFile(path string, data []byte) error {
 	return nil
 }
 
+func (m *mockRemoteFS) CreateFile(path string, data []byte) error {
+	if _, ok := m.files[path]; ok {
+		return fmt.Errorf("exists: %s", path)
+	}
+	m.files[path] = data
+	return nil
+}
+
 func (m *mockRemoteFS) Remove(path string) error {
 	delete(m.files, path)
 	delete(m.dirs, path)
@@ -103,10 +113,31 @@ func (m *mockRemoteFS) Rename(src, dst string) error {
 	return fmt.Errorf("not found: %s", src)
 }
 
+func (m *mockRemoteFS) Replace(src, dst string) error {
+	m.replaced = append(m.replaced, src+" -> "+dst)
+	if data, ok := m.files[src]; ok {
+		m.files[dst] = data
+		delete(m.files, src)
+	}
+	return nil
+}
+
 func (m *mockRemoteFS) FileHash(path string) (string, error) {
 	return "mockhash", nil
 }
 
+// TreeHashes uses the content of a file as its hash.
+func (m *mockRemoteFS) TreeHashes(dir string) (map[string]string, error) {
+	hashes := map[string]string{}
+	prefix := dir + "/"
+	for p, data := range m.files {
+		if len(p) > len(prefix) && p[:len(prefix)] == prefix {
+			hashes[p[len(prefix):]] = string(data)
+		}
+	}
+	return hashes, nil
+}
+
 func (m *mockRemoteFS) FileModTime(path string) (time.Time, error) {
 	return time.Now().UTC(), nil
 }
@@ -182,7 +213,7 @@ func TestDeploy_EmptyDir(t *testing.T) {
 	mockFS.files["/target/.cast/tmp/app.js"] = []byte("content")
 
 	svc := setupService(mockFS, mockExec, mockRepo, prompter)
-	err := svc.Deploy("/target/bundle.tar.gz", "/target", "app.tar.gz", "hash123")
+	err := svc.Deploy("/target/bundle.tar.gz", "/target", "app.tar.gz", "hash123", 0)
 	if err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
@@ -215,7 +246,7 @@ func TestDeploy_HasSnapshotManagedFiles(t *testing.T) {
 	mockFS.files["/target/.cast/tmp/app.js"] = []byte("new")
 
 	svc := setupService(mockFS, mockExec, mockRepo, prompter)
-	err := svc.Deploy("/target/bundle.tar.gz", "/target", "app.tar.gz", "hash123")
+	err := svc.Deploy("/target/bundle.tar.gz", "/target", "app.tar.gz", "hash123", 0)
 	if err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
@@ -244,7 +275,7 @@ func TestDeploy_HasSnapshotUnmanagedFiles(t *testing.T) {
 	mockFS.files["/target/.cast/tmp/config.yml"] = []byte("new config")
 
 	svc := setupService(mockFS, mockExec, mockRepo, prompter)
-	err := svc.Deploy("/target/bundle.tar.gz", "/target", "app.tar.gz", "hash123")
+	err := svc.Deploy("/target/bundle.tar.gz", "/target", "app.tar.gz", "hash123", 0)
 	if err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
@@ -266,7 +297,7 @@ func TestDeploy_NoSnapshotWithConflict(t *testing.T) {
 	mockFS.files["/target/.cast/tmp/app.js"] = []byte("new")
 
 	svc := setupService(mockFS, mockExec, mockRepo, prompter)
-	err := svc.Deploy("/target/bundle.tar.gz", "/target", "app.tar.gz", "hash123")
+	err := svc.Deploy("/target/bundle.tar.gz", "/target", "app.tar.gz", "hash123", 0)
 	if err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
@@ -288,7 +319,7 @@ func TestDeploy_UserChoosesRemove(t *testing.T) {
 	mockFS.files["/target/.cast/tmp/old.txt"] = []byte("new data")
 
 	svc := setupService(mockFS, mockExec, mockRepo, prompter)
-	err := svc.Deploy("/target/bundle.tar.gz", "/target", "app.tar.gz", "hash123")
+	err := svc.Deploy("/target/bundle.tar.gz", "/target", "app.tar.gz", "hash123", 0)
 	if err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
@@ -308,7 +339,7 @@ func TestDeploy_SnapshotWrittenAfterDeploy(t *testing.T) {
 	mockFS.files["/target/.cast/tmp/index.html"] = []byte("<html>")
 
 	svc := setupService(mockFS, mockExec, mockRepo, prompter)
-	err := svc.Deploy("/target/bundle.tar.gz", "/target", "bundle.tar.gz", "hash999")
+	err := svc.Deploy("/target/bundle.tar.gz", "/target", "bundle.tar.gz", "hash999", 0)
 	if err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
@@ -334,11 +365,11 @@ func TestDeploy_SnapshotPreservesHistory(t *testing.T) {
 	// First deploy
 	mockFS.files["/target/.cast/tmp/v1.js"] = []byte("v1")
 	svc := setupService(mockFS, mockExec, mockRepo, prompter)
-	_ = svc.Deploy("/target/bundle.tar.gz", "/target", "v1.tar.gz", "hash1")
+	_ = svc.Deploy("/target/bundle.tar.gz", "/target", "v1.tar.gz", "hash1", 0)
 
 	// Second deploy — existing file is now managed
 	mockFS.files["/target/.cast/tmp/v2.js"] = []byte("v2")
-	_ = svc.Deploy("/target/bundle2.tar.gz", "/target", "v2.tar.gz", "hash2")
+	_ = svc.Deploy("/target/bundle2.tar.gz", "/target", "v2.tar.gz", "hash2", 0)
 
 	snap := mockRepo.snapshots["/target"]
 	if snap == nil {
@@ -348,3 +379,107 @@ func TestDeploy_SnapshotPreservesHistory(t *testing.T) {
 		t.Errorf("expected 2 entries (history preserved), got %d", len(snap.Entries))
 	}
 }
+
+func TestUnchanged_SameBundle(t *testing.T) {
+	mockFS := newMockFS()
+	mockRepo := newMockSnapshotRepo()
+	mockFS.files["/target/app.js"] = []byte("v1")
+	mockRepo.snapshots["/target"] = &domain.Snapshot{
+		Entries: []domain.SnapshotEntry{
+			{BundleName: "app.tar.gz", BundleHash: "hash1", Files: []domain.FileRecord{{Path: "app.js", Hash: "mockhash"}}},
+		},
+	}
+	svc := setupService(mockFS, newMockExec(), mockRepo, &mockPrompter{})
+
+	unchanged, err := svc.Unchanged("/target", "app.tar.gz", "hash1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !unchanged {
+		t.Error("expected same bundle hash to be unchanged")
+	}
+
+	unchanged, _ = svc.Unchanged("/target", "app.tar.gz", "hash2")
+	if unchanged {
+		t.Error("expected different bundle hash to be changed")
+	}
+}
+
+func TestUnchanged_FileModified(t *testing.T) {
+	mockFS := newMockFS()
+	mockRepo := newMockSnapshotRepo()
+	mockFS.files["/target/app.js"] = []byte("edited")
+	mockRepo.snapshots["/target"] = &domain.Snapshot{
+		Entries: []domain.SnapshotEntry{
+			{BundleName: "app.tar.gz", BundleHash: "hash1", Files: []domain.FileRecord{{Path: "app.js", Hash: "deployedhash"}}},
+		},
+	}
+	svc := setupService(mockFS, newMockExec(), mockRepo, &mockPrompter{})
+
+	unchanged, err := svc.Unchanged("/target", "app.tar.gz", "hash1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if unchanged {
+		t.Error("expected modified files to require a new upload")
+	}
+}
+
+func TestConflicts_ReadOnly(t *testing.T) {
+	mockFS := newMockFS()
+	mockExec := newMockExec()
+	mockRepo := newMockSnapshotRepo()
+	mockFS.files["/target/app.js"] = []byte("v1")
+	mockFS.files["/target/config.yml"] = []byte("manual")
+	mockRepo.snapshots["/target"] = &domain.Snapshot{
+		Entries: []domain.SnapshotEntry{
+			{BundleName: "app.tar.gz", Files: []domain.FileRecord{{Path: "app.js"}}},
+		},
+	}
+	svc := setupService(mockFS, mockExec, mockRepo, &mockPrompter{})
+
+	result, err := svc.Conflicts("/target", []string{"app.js", "config.yml", "new.txt"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(result.ManagedFiles) != 1 || result.ManagedFiles[0] != "app.js" {
+		t.Errorf("expected app.js to be managed, got %v", result.ManagedFiles)
+	}
+	if len(result.UnmanagedFiles) != 1 || result.UnmanagedFiles[0] != "config.yml" {
+		t.Errorf("expected config.yml to be unmanaged, got %v", result.UnmanagedFiles)
+	}
+	if len(mockExec.commands) != 0 {
+		t.Errorf("expected no remote commands, got %v", mockExec.commands)
+	}
+}
+
+func TestDeploy_PrunesHistory(t *testing.T) {
+	mockFS := newMockFS()
+	mockRepo := newMockSnapshotRepo()
+	var entries []domain.SnapshotEntry
+	for _, id := range []string{"1", "2", "3"} {
+		entries = append(entries, domain.SnapshotEntry{ID: id, BundleName: "app.tar.gz", Files: []domain.FileRecord{{Path: "app.js"}}})
+		mockFS.dirs["/target/.cast/history/"+id] = true
+	}
+	mockRepo.snapshots["/target"] = &domain.Snapshot{Entries: entries}
+	mockFS.files["/target/app.js"] = []byte("v3")
+	mockFS.files["/target/.cast/tmp/app.js"] = []byte("v4")
+
+	svc := setupService(mockFS, newMockExec(), mockRepo, &mockPrompter{})
+	if err := svc.Deploy("/target/bundle.tar.gz", "/target", "app.tar.gz", "hash4", 2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	snap := mockRepo.snapshots["/target"]
+	if len(snap.Entries) != 2 || snap.Entries[0].ID != "3" || snap.Entries[1].ID != "4" {
+		t.Fatalf("expected entries 3 and 4 kept, got %+v", snap.Entries)
+	}
+	for _, id := range []string{"1", "2"} {
+		if mockFS.dirs["/target/.cast/history/"+id] {
+			t.Errorf("expected history of entry %s removed", id)
+		}
+	}
+	if _, ok := mockFS.files["/target/.cast/history/3/app.js"]; !ok {
+		t.Error("expected app.js of entry 3 archived")
+	}
+}
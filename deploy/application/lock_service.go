@@ -0,0 +1,134 @@
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/koyeo/cast/deploy/domain"
+	"github.com/koyeo/cast/i18n"
+)
+
+// defaultLockPoll is how often a held lock is checked while waiting.
+const defaultLockPoll = 2 * time.Second
+
+// LockService guards a target directory against concurrent deploys with a
+// lock file created under its .cast/ directory.
+type LockService struct {
+	fs   domain.RemoteFS
+	lang string
+	out  io.Writer
+	poll time.Duration
+}
+
+// NewLockService creates a new LockService with all dependencies injected.
+func NewLockService(fs domain.RemoteFS, lang string) *LockService {
+	return &LockService{
+		fs:   fs,
+		lang: lang,
+		out:  os.Stdout,
+		poll: defaultLockPoll,
+	}
+}
+
+// SetOutput redirects progress messages.
+func (s *LockService) SetOutput(out io.Writer) {
+	s.out = out
+}
+
+// Acquire takes the lock of targetDir for lock. When another deploy holds
+// it, Acquire waits up to wait for it to be released, then fails with the
+// current holder.
+func (s *LockService) Acquire(targetDir string, lock *domain.Lock, wait time.Duration) error {
+	if err := s.fs.MkdirAll(fmt.Sprintf("%s/.cast", targetDir)); err != nil {
+		return fmt.Errorf("make .cast dir error: %s", err)
+	}
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode lock error: %s", err)
+	}
+	lockPath := fmt.Sprintf("%s/%s", targetDir, domain.LockFileName)
+	deadline := time.Now().Add(wait)
+	waiting, raced := false, false
+	for {
+		createErr := s.fs.CreateFile(lockPath, data)
+		if createErr == nil {
+			return nil
+		}
+		held, readErr := s.Read(targetDir)
+		if readErr != nil {
+			return readErr
+		}
+		if held == nil {
+			// released between the two calls: retry at once, but a
+			// creation failing for another reason only until the deadline
+			if !raced {
+				raced = true
+				continue
+			}
+			if !time.Now().Before(deadline) {
+				return fmt.Errorf("create lock error: %s", createErr)
+			}
+			time.Sleep(s.poll)
+			continue
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("target: %s is locked by %s, run `cast unlock` if the lock is stale", targetDir, held)
+		}
+		if !waiting {
+			waiting = true
+			fmt.Fprintln(s.out, i18n.Msgf(i18n.MsgWaitingLock, s.lang, held))
+		}
+		time.Sleep(s.poll)
+	}
+}
+
+// Release removes the lock of targetDir if it is still held by lock.
+func (s *LockService) Release(targetDir string, lock *domain.Lock) error {
+	held, err := s.Read(targetDir)
+	if err != nil {
+		return err
+	}
+	if !lock.SameHolder(held) {
+		return nil
+	}
+	if err = s.fs.Remove(fmt.Sprintf("%s/%s", targetDir, domain.LockFileName)); err != nil {
+		return fmt.Errorf("remove lock error: %s", err)
+	}
+	return nil
+}
+
+// Read returns the lock of targetDir, nil if it is not locked.
+func (s *LockService) Read(targetDir string) (*domain.Lock, error) {
+	lockPath := fmt.Sprintf("%s/%s", targetDir, domain.LockFileName)
+	if _, err := s.fs.Stat(lockPath); err != nil {
+		return nil, nil
+	}
+	data, err := s.fs.ReadFile(lockPath)
+	if err != nil {
+		return nil, fmt.Errorf("read lock error: %s", err)
+	}
+	lock := &domain.Lock{}
+	if err = json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("decode lock error: %s", err)
+	}
+	return lock, nil
+}
+
+// Unlock removes the lock of targetDir when it is older than olderThan.
+// Returns the lock found (nil if none) and whether it was removed.
+func (s *LockService) Unlock(targetDir string, olderThan time.Duration) (*domain.Lock, bool, error) {
+	held, err := s.Read(targetDir)
+	if err != nil || held == nil {
+		return held, false, err
+	}
+	if held.Age(time.Now()) < olderThan {
+		return held, false, nil
+	}
+	if err = s.fs.Remove(fmt.Sprintf("%s/%s", targetDir, domain.LockFileName)); err != nil {
+		return held, false, fmt.Errorf("remove lock error: %s", err)
+	}
+	return held, true, nil
+}
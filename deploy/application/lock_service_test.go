@@ -0,0 +1,115 @@
+package application
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koyeo/cast/deploy/domain"
+)
+
+func testLock(holder string, age time.Duration) *domain.Lock {
+	return &domain.Lock{Holder: holder, Host: "laptop", PID: 42, AcquiredAt: time.Now().Add(-age).UTC()}
+}
+
+func TestLock_AcquireAndRelease(t *testing.T) {
+	mockFS := newMockFS()
+	svc := NewLockService(mockFS, "en")
+	lock := testLock("alice", 0)
+
+	if err := svc.Acquire("/target", lock, 0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	held, err := svc.Read("/target")
+	if err != nil || !lock.SameHolder(held) {
+		t.Fatalf("expected lock of alice, got %+v (%v)", held, err)
+	}
+	if err = svc.Release("/target", lock); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := mockFS.files["/target/.cast/deploy.lock"]; ok {
+		t.Error("expected lock file to be removed")
+	}
+}
+
+func TestLock_HeldFails(t *testing.T) {
+	svc := NewLockService(newMockFS(), "en")
+	svc.poll = time.Millisecond
+	if err := svc.Acquire("/target", testLock("alice", 0), 0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	err := svc.Acquire("/target", testLock("bob", 0), 5*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "alice@laptop") {
+		t.Fatalf("expected error naming the holder, got %v", err)
+	}
+}
+
+func TestLock_ReleaseKeepsOthersLock(t *testing.T) {
+	mockFS := newMockFS()
+	svc := NewLockService(mockFS, "en")
+	if err := svc.Acquire("/target", testLock("alice", 0), 0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := svc.Release("/target", testLock("bob", 0)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := mockFS.files["/target/.cast/deploy.lock"]; !ok {
+		t.Error("expected lock of alice to be kept")
+	}
+}
+
+func TestLock_UnlockOlderThan(t *testing.T) {
+	svc := NewLockService(newMockFS(), "en")
+	if err := svc.Acquire("/target", testLock("alice", 10*time.Minute), 0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	lock, removed, err := svc.Unlock("/target", time.Hour)
+	if err != nil || lock == nil || removed {
+		t.Fatalf("expected young lock to be kept, got %+v %v %v", lock, removed, err)
+	}
+	lock, removed, err = svc.Unlock("/target", 5*time.Minute)
+	if err != nil || !removed {
+		t.Fatalf("expected stale lock to be removed, got %+v %v %v", lock, removed, err)
+	}
+	if lock, _ = svc.Read("/target"); lock != nil {
+		t.Errorf("expected no lock, got %+v", lock)
+	}
+}
+
+// failingCreateFS fails to create any file, as a read-only or full disk does.
+type failingCreateFS struct {
+	*mockRemoteFS
+	creates int
+}
+
+func (m *failingCreateFS) CreateFile(path string, data []byte) error {
+	m.creates++
+	return fmt.Errorf("permission denied")
+}
+
+func TestLock_CreateFails(t *testing.T) {
+	for _, wait := range []time.Duration{0, 5 * time.Millisecond} {
+		mockFS := &failingCreateFS{mockRemoteFS: newMockFS()}
+		svc := NewLockService(mockFS, "en")
+		svc.poll = time.Millisecond
+
+		done := make(chan error, 1)
+		go func() {
+			done <- svc.Acquire("/target", testLock("alice", 0), wait)
+		}()
+		select {
+		case err := <-done:
+			if err == nil || !strings.Contains(err.Error(), "permission denied") {
+				t.Fatalf("expected the create error, got %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected Acquire to give up with wait %s", wait)
+		}
+		if wait == 0 && mockFS.creates != 2 {
+			t.Errorf("expected one retry without wait, got %d creates", mockFS.creates)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package application
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/koyeo/cast/deploy/domain"
+	"github.com/koyeo/cast/i18n"
+)
+
+// DeployRelease extracts the bundle into a new releases/<time> directory,
+// atomically points the `current` symlink at it and prunes old releases,
+// keeping the newest keep ones. The service is never half-updated: until
+// the symlink flips, the previous release is served untouched.
+func (s *DeployService) DeployRelease(bundleRemotePath, targetDir, bundleName, bundleHash string, keep int) error {
+	releasesDir := fmt.Sprintf("%s/%s", targetDir, domain.ReleasesDir)
+	name := domain.NextReleaseName(time.Now(), func(candidate string) bool {
+		_, statErr := s.fs.Stat(fmt.Sprintf("%s/%s", releasesDir, candidate))
+		return statErr == nil
+	})
+	release := fmt.Sprintf("%s/%s", domain.ReleasesDir, name)
+	releaseDir := fmt.Sprintf("%s/%s", targetDir, release)
+
+	cmd := fmt.Sprintf("mkdir -p %s && tar -xzf %s -C %s", releaseDir, bundleRemotePath, releaseDir)
+	if err := s.exec.Exec(cmd); err != nil {
+		_ = s.exec.Exec(fmt.Sprintf("rm -rf %s", releaseDir))
+		return fmt.Errorf("extract bundle error: %s", err)
+	}
+
+	extractedFiles, err := s.fs.ReadDir(releaseDir)
+	if err != nil {
+		return fmt.Errorf("list extracted files error: %s", err)
+	}
+	var fileRecords []domain.FileRecord
+	for _, f := range extractedFiles {
+		record, err := recordFile(s.fs, releaseDir, f)
+		if err != nil {
+			_ = s.exec.Exec(fmt.Sprintf("rm -rf %s", releaseDir))
+			return err
+		}
+		fileRecords = append(fileRecords, record)
+	}
+
+	if err = switchCurrent(s.fs, s.exec, targetDir, release); err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, i18n.Msgf(i18n.MsgReleaseSwitched, s.lang, release))
+
+	snap, err := s.snapshot.Read(targetDir)
+	if err != nil {
+		return fmt.Errorf("read snapshot error: %s", err)
+	}
+	if snap == nil {
+		snap = &domain.Snapshot{}
+		fmt.Fprintln(s.out, i18n.Msg(i18n.MsgSnapshotCreated, s.lang))
+	} else {
+		fmt.Fprintln(s.out, i18n.Msg(i18n.MsgSnapshotUpdated, s.lang))
+	}
+	entry := domain.NewSnapshotEntry(bundleName, bundleHash, fileRecords)
+	entry.Release = release
+	snap.AddEntry(entry)
+	if err = s.snapshot.Write(targetDir, snap); err != nil {
+		return fmt.Errorf("write snapshot error: %s", err)
+	}
+
+	if err = s.pruneReleases(targetDir, name, keep); err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, i18n.Msg(i18n.MsgDeployComplete, s.lang))
+	return nil
+}
+
+// pruneReleases removes the oldest release directories beyond keep.
+func (s *DeployService) pruneReleases(targetDir, current string, keep int) error {
+	releasesDir := fmt.Sprintf("%s/%s", targetDir, domain.ReleasesDir)
+	releases, err := s.fs.ReadDir(releasesDir)
+	if err != nil {
+		return fmt.Errorf("list releases error: %s", err)
+	}
+	for _, name := range domain.ReleasesToPrune(releases, current, keep) {
+		fmt.Fprintln(s.out, i18n.Msgf(i18n.MsgRemoving, s.lang, fmt.Sprintf("%s/%s", domain.ReleasesDir, name)))
+		if err = s.fs.Remove(fmt.Sprintf("%s/%s", releasesDir, name)); err != nil {
+			return fmt.Errorf("remove release error: %s", err)
+		}
+	}
+	return nil
+}
+
+// switchCurrent atomically repoints the current symlink at release:
+// a new link is created aside and renamed over the old one.
+func switchCurrent(fs domain.RemoteFS, exec domain.RemoteExec, targetDir, release string) error {
+	tmpLink := fmt.Sprintf("%s/.%s~", targetDir, domain.CurrentLink)
+	if err := exec.Exec(fmt.Sprintf("rm -f %s && ln -s %s %s", tmpLink, release, tmpLink)); err != nil {
+		return fmt.Errorf("create current release link error: %s", err)
+	}
+	if err := fs.Replace(tmpLink, fmt.Sprintf("%s/%s", targetDir, domain.CurrentLink)); err != nil {
+		return fmt.Errorf("switch current release error: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package application
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/koyeo/cast/deploy/domain"
+)
+
+func TestDeployRelease_SwitchesCurrent(t *testing.T) {
+	mockFS := newMockFS()
+	mockExec := newMockExec()
+	mockRepo := newMockSnapshotRepo()
+
+	svc := NewDeployService(mockFS, mockExec, mockRepo, &mockPrompter{}, "en")
+	err := svc.DeployRelease("/target/bundle.tar.gz~", "/target", "app.tar.gz", "hash1", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	snap := mockRepo.snapshots["/target"]
+	if snap == nil || len(snap.Entries) != 1 {
+		t.Fatalf("expected one snapshot entry")
+	}
+	release := snap.Entries[0].Release
+	if !strings.HasPrefix(release, "releases/") {
+		t.Fatalf("expected entry to record its release dir, got %q", release)
+	}
+	if len(mockExec.commands) != 2 {
+		t.Fatalf("expected extract and switch commands, got %v", mockExec.commands)
+	}
+	if !strings.Contains(mockExec.commands[0], "tar -xzf /target/bundle.tar.gz~ -C /target/"+release) {
+		t.Errorf("expected extract into release dir, got %s", mockExec.commands[0])
+	}
+	if !strings.Contains(mockExec.commands[1], "ln -s "+release+" /target/.current~") {
+		t.Errorf("expected a current symlink created aside, got %s", mockExec.commands[1])
+	}
+	if len(mockFS.replaced) != 1 || mockFS.replaced[0] != "/target/.current~ -> /target/current" {
+		t.Errorf("expected the link renamed over current, got %v", mockFS.replaced)
+	}
+}
+
+func TestDeployRelease_PrunesOldReleases(t *testing.T) {
+	mockFS := newMockFS()
+	mockRepo := newMockSnapshotRepo()
+	for _, name := range []string{"20200101T000000Z", "20200102T000000Z", "20200103T000000Z"} {
+		mockFS.files["/target/releases/"+name] = []byte("")
+	}
+
+	svc := NewDeployService(mockFS, newMockExec(), mockRepo, &mockPrompter{}, "en")
+	if err := svc.DeployRelease("/target/bundle.tar.gz~", "/target", "app.tar.gz", "hash1", 2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, ok := mockFS.files["/target/releases/20200101T000000Z"]; ok {
+		t.Error("expected oldest release to be pruned")
+	}
+	for _, name := range []string{"20200102T000000Z", "20200103T000000Z"} {
+		if _, ok := mockFS.files["/target/releases/"+name]; !ok {
+			t.Errorf("expected release %s to be kept", name)
+		}
+	}
+}
+
+func TestRollback_Release(t *testing.T) {
+	mockFS := newMockFS()
+	mockExec := newMockExec()
+	mockRepo := newMockSnapshotRepo()
+	mockFS.dirs["/target/releases/r1"] = true
+	mockRepo.snapshots["/target"] = &domain.Snapshot{
+		Entries: []domain.SnapshotEntry{
+			{ID: "1", BundleName: "app.tar.gz", BundleHash: "hash1", Release: "releases/r1"},
+			{ID: "2", BundleName: "app.tar.gz", BundleHash: "hash2", Release: "releases/r2"},
+		},
+	}
+
+	svc := NewRollbackService(mockFS, mockExec, mockRepo, "en")
+	entry, err := svc.Rollback("/target", "app.tar.gz", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(mockExec.commands) != 1 || !strings.Contains(mockExec.commands[0], "ln -s releases/r1") {
+		t.Errorf("expected a single symlink flip, got %v", mockExec.commands)
+	}
+	if len(mockFS.replaced) != 1 || mockFS.replaced[0] != "/target/.current~ -> /target/current" {
+		t.Errorf("expected the link renamed over current, got %v", mockFS.replaced)
+	}
+	if entry.Release != "releases/r1" || entry.RollbackOf != "1" {
+		t.Errorf("unexpected rollback entry: %+v", entry)
+	}
+}
+
+func TestRollback_ReleasePruned(t *testing.T) {
+	mockRepo := newMockSnapshotRepo()
+	mockRepo.snapshots["/target"] = &domain.Snapshot{
+		Entries: []domain.SnapshotEntry{
+			{ID: "1", BundleName: "app.tar.gz", Release: "releases/r1"},
+			{ID: "2", BundleName: "app.tar.gz", Release: "releases/r2"},
+		},
+	}
+
+	svc := NewRollbackService(newMockFS(), newMockExec(), mockRepo, "en")
+	if _, err := svc.Rollback("/target", "app.tar.gz", ""); err == nil {
+		t.Fatal("expected error for pruned release")
+	}
+}
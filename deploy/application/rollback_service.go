@@ -0,0 +1,167 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/koyeo/cast/deploy/domain"
+	"github.com/koyeo/cast/i18n"
+)
+
+// ErrOtherBundle is returned by Rollback when the entry to restore is
+// a deploy of another bundle sharing the target directory.
+var ErrOtherBundle = errors.New("entry of another bundle")
+
+// RollbackService restores files of a previous snapshot entry from the
+// history kept under .cast/history/ by DeployService.
+type RollbackService struct {
+	fs       domain.RemoteFS
+	exec     domain.RemoteExec
+	snapshot domain.SnapshotRepository
+	lang     string
+	out      io.Writer
+}
+
+// NewRollbackService creates a new RollbackService with all dependencies injected.
+func NewRollbackService(
+	fs domain.RemoteFS,
+	exec domain.RemoteExec,
+	snapshot domain.SnapshotRepository,
+	lang string,
+) *RollbackService {
+	return &RollbackService{
+		fs:       fs,
+		exec:     exec,
+		snapshot: snapshot,
+		lang:     lang,
+		out:      os.Stdout,
+	}
+}
+
+// SetOutput redirects progress messages.
+func (s *RollbackService) SetOutput(out io.Writer) {
+	s.out = out
+}
+
+// Rollback restores the files of entry `to` in targetDir, archiving the
+// files of the current entry so the rollback itself can be undone.
+//
+// Parameters:
+//   - targetDir: the target directory the bundle was deployed to
+//   - bundleName: limits the rollback to entries of this bundle (all if empty)
+//   - to: ID of the entry to restore, defaults to the one before the latest;
+//     ErrOtherBundle if it is a deploy of another bundle, nothing is touched
+//
+// Returns the rollback entry appended to the snapshot.
+func (s *RollbackService) Rollback(targetDir, bundleName, to string) (*domain.SnapshotEntry, error) {
+	snap, err := s.snapshot.Read(targetDir)
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot error: %s", err)
+	}
+	current := snap.LatestEntry(bundleName)
+	if current < 0 {
+		return nil, fmt.Errorf("no deploy of %s recorded in %s", bundleName, targetDir)
+	}
+
+	target := -1
+	if to == "" {
+		target = snap.PreviousEntry(current, bundleName)
+		if target < 0 {
+			return nil, fmt.Errorf("no previous deploy of %s to rollback to in %s", bundleName, targetDir)
+		}
+	} else {
+		target = snap.FindEntry(to)
+		if target < 0 {
+			return nil, fmt.Errorf("snapshot entry: %s not found in %s", to, targetDir)
+		}
+		if bundleName != "" && snap.Entries[target].BundleName != bundleName {
+			return nil, fmt.Errorf("%w: snapshot entry: %s deployed %s, not %s",
+				ErrOtherBundle, to, snap.Entries[target].BundleName, bundleName)
+		}
+	}
+	if target == current {
+		return nil, fmt.Errorf("snapshot entry: %s is already deployed in %s", snap.EntryID(target), targetDir)
+	}
+	targetID := snap.EntryID(target)
+	restore := snap.Entries[target]
+	if restore.Release != "" {
+		return s.rollbackRelease(targetDir, snap, targetID)
+	}
+
+	// Make sure every file can be restored before touching live files
+	for _, f := range restore.Files {
+		src := fmt.Sprintf("%s/%s", historyDir(targetDir, targetID), f.Path)
+		if _, statErr := s.fs.Stat(src); statErr != nil {
+			if _, liveErr := s.fs.Stat(fmt.Sprintf("%s/%s", targetDir, f.Path)); liveErr == nil && snap.Owner(f.Path) == targetID {
+				continue
+			}
+			return nil, fmt.Errorf("file: %s of entry: %s is not kept in history", f.Path, targetID)
+		}
+	}
+
+	// Archive the current files
+	for _, f := range snap.Entries[current].Files {
+		if snap.Owner(f.Path) == targetID {
+			continue
+		}
+		if _, statErr := s.fs.Stat(fmt.Sprintf("%s/%s", targetDir, f.Path)); statErr != nil {
+			continue
+		}
+		if err = archiveFile(s.fs, targetDir, snap.Owner(f.Path), f.Path); err != nil {
+			return nil, fmt.Errorf("archive file error: %s", err)
+		}
+	}
+
+	// Copy the kept versions back, history stays intact for later rollbacks
+	var fileRecords []domain.FileRecord
+	for _, f := range restore.Files {
+		src := fmt.Sprintf("%s/%s", historyDir(targetDir, targetID), f.Path)
+		dst := fmt.Sprintf("%s/%s", targetDir, f.Path)
+		if _, statErr := s.fs.Stat(src); statErr == nil {
+			fmt.Fprintln(s.out, i18n.Msgf(i18n.MsgRestoring, s.lang, f.Path, targetID))
+			if err = s.exec.Exec(fmt.Sprintf("rm -rf %s && cp -a %s %s", dst, src, dst)); err != nil {
+				return nil, fmt.Errorf("restore file error: %s", err)
+			}
+		}
+		record, err := recordFile(s.fs, targetDir, f.Path)
+		if err != nil {
+			return nil, err
+		}
+		fileRecords = append(fileRecords, record)
+	}
+
+	entry := domain.NewSnapshotEntry(restore.BundleName, restore.BundleHash, fileRecords)
+	entry.RollbackOf = targetID
+	snap.AddEntry(entry)
+	if err = s.snapshot.Write(targetDir, snap); err != nil {
+		return nil, fmt.Errorf("write snapshot error: %s", err)
+	}
+
+	fmt.Fprintln(s.out, i18n.Msgf(i18n.MsgRollbackComplete, s.lang, targetID))
+	return &snap.Entries[len(snap.Entries)-1], nil
+}
+
+// rollbackRelease points the current symlink back at the release of entry
+// targetID, no files are copied.
+func (s *RollbackService) rollbackRelease(targetDir string, snap *domain.Snapshot, targetID string) (*domain.SnapshotEntry, error) {
+	restore := snap.Entries[snap.FindEntry(targetID)]
+	if _, err := s.fs.Stat(restore.Dir(targetDir)); err != nil {
+		return nil, fmt.Errorf("release: %s of entry: %s was pruned", restore.Release, targetID)
+	}
+	if err := switchCurrent(s.fs, s.exec, targetDir, restore.Release); err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(s.out, i18n.Msgf(i18n.MsgReleaseSwitched, s.lang, restore.Release))
+
+	entry := domain.NewSnapshotEntry(restore.BundleName, restore.BundleHash, restore.Files)
+	entry.Release = restore.Release
+	entry.RollbackOf = targetID
+	snap.AddEntry(entry)
+	if err := s.snapshot.Write(targetDir, snap); err != nil {
+		return nil, fmt.Errorf("write snapshot error: %s", err)
+	}
+	fmt.Fprintln(s.out, i18n.Msgf(i18n.MsgRollbackComplete, s.lang, targetID))
+	return &snap.Entries[len(snap.Entries)-1], nil
+}
@@ -0,0 +1,170 @@
+package application
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/koyeo/cast/deploy/domain"
+)
+
+func twoDeploys() *domain.Snapshot {
+	return &domain.Snapshot{
+		Entries: []domain.SnapshotEntry{
+			{ID: "1", BundleName: "app.tar.gz", BundleHash: "hash1", Files: []domain.FileRecord{{Path: "app.js"}}},
+			{ID: "2", BundleName: "app.tar.gz", BundleHash: "hash2", Files: []domain.FileRecord{{Path: "app.js"}}},
+		},
+	}
+}
+
+func TestRollback_ToPrevious(t *testing.T) {
+	mockFS := newMockFS()
+	mockExec := newMockExec()
+	mockRepo := newMockSnapshotRepo()
+	mockFS.files["/target/app.js"] = []byte("v2")
+	mockFS.files["/target/.cast/history/1/app.js"] = []byte("v1")
+	mockRepo.snapshots["/target"] = twoDeploys()
+
+	svc := NewRollbackService(mockFS, mockExec, mockRepo, "en")
+	entry, err := svc.Rollback("/target", "app.tar.gz", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Current version is archived so the rollback can be undone
+	if string(mockFS.files["/target/.cast/history/2/app.js"]) != "v2" {
+		t.Error("expected current app.js to be archived under history/2")
+	}
+	restored := false
+	for _, c := range mockExec.commands {
+		if strings.Contains(c, "cp -a /target/.cast/history/1/app.js /target/app.js") {
+			restored = true
+		}
+	}
+	if !restored {
+		t.Errorf("expected restore command, got %v", mockExec.commands)
+	}
+	if entry.RollbackOf != "1" || entry.BundleHash != "hash1" {
+		t.Errorf("unexpected rollback entry: %+v", entry)
+	}
+	if len(mockRepo.snapshots["/target"].Entries) != 3 {
+		t.Errorf("expected rollback entry to be appended")
+	}
+}
+
+func TestRollback_ToEntry(t *testing.T) {
+	mockFS := newMockFS()
+	mockRepo := newMockSnapshotRepo()
+	mockFS.files["/target/app.js"] = []byte("v2")
+	mockFS.files["/target/.cast/history/1/app.js"] = []byte("v1")
+	mockRepo.snapshots["/target"] = twoDeploys()
+
+	svc := NewRollbackService(mockFS, newMockExec(), mockRepo, "en")
+	if _, err := svc.Rollback("/target", "app.tar.gz", "9"); err == nil {
+		t.Error("expected error for unknown entry")
+	}
+	if _, err := svc.Rollback("/target", "app.tar.gz", "2"); err == nil {
+		t.Error("expected error when rolling back to the current entry")
+	}
+	if _, err := svc.Rollback("/target", "app.tar.gz", "1"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestRollback_MissingHistory(t *testing.T) {
+	mockFS := newMockFS()
+	mockRepo := newMockSnapshotRepo()
+	mockFS.files["/target/app.js"] = []byte("v2")
+	mockRepo.snapshots["/target"] = twoDeploys()
+
+	svc := NewRollbackService(mockFS, newMockExec(), mockRepo, "en")
+	if _, err := svc.Rollback("/target", "app.tar.gz", ""); err == nil {
+		t.Fatal("expected error when previous version was not kept")
+	}
+	// Live file must be left untouched
+	if string(mockFS.files["/target/app.js"]) != "v2" {
+		t.Error("expected live file to be untouched")
+	}
+}
+
+func TestRollback_EntryOfOtherBundle(t *testing.T) {
+	// two mappers deploy app.tar.gz and web.tar.gz into one target dir
+	mockFS := newMockFS()
+	mockExec := newMockExec()
+	mockRepo := newMockSnapshotRepo()
+	mockFS.files["/target/app.js"] = []byte("app v2")
+	mockFS.files["/target/web.js"] = []byte("web v1")
+	mockFS.files["/target/.cast/history/1/app.js"] = []byte("app v1")
+	mockRepo.snapshots["/target"] = &domain.Snapshot{
+		Entries: []domain.SnapshotEntry{
+			{ID: "1", BundleName: "app.tar.gz", BundleHash: "app1", Files: []domain.FileRecord{{Path: "app.js"}}},
+			{ID: "2", BundleName: "web.tar.gz", BundleHash: "web1", Files: []domain.FileRecord{{Path: "web.js"}}},
+			{ID: "3", BundleName: "app.tar.gz", BundleHash: "app2", Files: []domain.FileRecord{{Path: "app.js"}}},
+		},
+	}
+
+	svc := NewRollbackService(mockFS, mockExec, mockRepo, "en")
+	// --to 1 applies to every mapper, the web mapper must be left alone
+	_, err := svc.Rollback("/target", "web.tar.gz", "1")
+	if !errors.Is(err, ErrOtherBundle) {
+		t.Fatalf("expected ErrOtherBundle, got %v", err)
+	}
+	if string(mockFS.files["/target/web.js"]) != "web v1" || string(mockFS.files["/target/app.js"]) != "app v2" {
+		t.Error("expected live files untouched")
+	}
+	if len(mockExec.commands) != 0 || len(mockRepo.snapshots["/target"].Entries) != 3 {
+		t.Errorf("expected nothing restored nor recorded, got %v", mockExec.commands)
+	}
+
+	entry, err := svc.Rollback("/target", "app.tar.gz", "1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if entry.BundleName != "app.tar.gz" || entry.RollbackOf != "1" {
+		t.Errorf("unexpected rollback entry: %+v", entry)
+	}
+	if string(mockFS.files["/target/web.js"]) != "web v1" {
+		t.Error("expected the file of the other bundle untouched")
+	}
+	if string(mockFS.files["/target/.cast/history/3/app.js"]) != "app v2" {
+		t.Error("expected current app.js archived under history/3")
+	}
+}
+
+func TestRollback_NoPrevious(t *testing.T) {
+	mockRepo := newMockSnapshotRepo()
+	mockRepo.snapshots["/target"] = &domain.Snapshot{
+		Entries: []domain.SnapshotEntry{
+			{ID: "1", BundleName: "app.tar.gz", Files: []domain.FileRecord{{Path: "app.js"}}},
+		},
+	}
+	svc := NewRollbackService(newMockFS(), newMockExec(), mockRepo, "en")
+	if _, err := svc.Rollback("/target", "app.tar.gz", ""); err == nil {
+		t.Error("expected error without a previous deploy")
+	}
+}
+
+func TestDeploy_ManagedFileArchived(t *testing.T) {
+	mockFS := newMockFS()
+	mockRepo := newMockSnapshotRepo()
+	prompter := &mockPrompter{action: domain.ActionBackup, suffix: ".bak"}
+
+	mockFS.files["/target/app.js"] = []byte("v1")
+	mockRepo.snapshots["/target"] = &domain.Snapshot{
+		Entries: []domain.SnapshotEntry{
+			{BundleName: "app.tar.gz", Files: []domain.FileRecord{{Path: "app.js"}}},
+		},
+	}
+	mockFS.files["/target/.cast/tmp/app.js"] = []byte("v2")
+
+	svc := setupService(mockFS, newMockExec(), mockRepo, prompter)
+	if err := svc.Deploy("/target/bundle.tar.gz", "/target", "app.tar.gz", "hash2", 0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(mockFS.files["/target/.cast/history/1/app.js"]) != "v1" {
+		t.Error("expected replaced managed file to be kept in history/1")
+	}
+	if mockRepo.snapshots["/target"].Entries[1].ID != "2" {
+		t.Errorf("expected new entry id '2', got '%s'", mockRepo.snapshots["/target"].Entries[1].ID)
+	}
+}
@@ -0,0 +1,126 @@
+package application
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/koyeo/cast/deploy/domain"
+	"github.com/koyeo/cast/i18n"
+)
+
+// SyncService applies incremental directory syncs (mapper mode: sync),
+// transferring only files whose hash differs from the remote manifest.
+type SyncService struct {
+	fs       domain.RemoteFS
+	exec     domain.RemoteExec
+	snapshot domain.SnapshotRepository
+	manifest domain.ManifestRepository
+	lang     string
+	out      io.Writer
+}
+
+// NewSyncService creates a new SyncService with all dependencies injected.
+func NewSyncService(
+	fs domain.RemoteFS,
+	exec domain.RemoteExec,
+	snapshot domain.SnapshotRepository,
+	manifest domain.ManifestRepository,
+	lang string,
+) *SyncService {
+	return &SyncService{
+		fs:       fs,
+		exec:     exec,
+		snapshot: snapshot,
+		manifest: manifest,
+		lang:     lang,
+		out:      os.Stdout,
+	}
+}
+
+// SetOutput redirects progress messages.
+func (s *SyncService) SetOutput(out io.Writer) {
+	s.out = out
+}
+
+// Plan compares local file hashes with the remote manifest of bundleName.
+// A nil manifest means the directory was never synced and needs a full deploy.
+func (s *SyncService) Plan(targetDir, bundleName string, local map[string]string, deleteExtra bool) (domain.SyncPlan, *domain.Manifest, error) {
+	manifest, err := s.manifest.Read(targetDir, bundleName)
+	if err != nil {
+		return domain.SyncPlan{}, nil, err
+	}
+	return domain.PlanSync(local, manifest, deleteExtra), manifest, nil
+}
+
+// SaveManifest records local as the synced state of bundleName.
+func (s *SyncService) SaveManifest(targetDir, bundleName string, local map[string]string) error {
+	paths := make([]string, 0, len(local))
+	for p := range local {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	manifest := &domain.Manifest{}
+	for _, p := range paths {
+		manifest.Files = append(manifest.Files, domain.FileRecord{Path: p, Hash: local[p]})
+	}
+	return s.manifest.Write(targetDir, bundleName, manifest)
+}
+
+// Apply extracts the bundle of changed files over targetDir, removes the
+// deleted files, then updates the manifest and the snapshot.
+//
+// Parameters:
+//   - bundleRemotePath: uploaded tar.gz of plan.Upload, empty if nothing to upload
+//   - targetDir: the target directory where files should be synced
+//   - bundleName: name of the bundle (e.g., "site.tar.gz")
+//   - sourceName: top level directory name, recorded as the managed file
+//   - local: hashes of all local files, the new manifest
+//   - plan: files to upload and delete
+func (s *SyncService) Apply(bundleRemotePath, targetDir, bundleName, sourceName string, local map[string]string, plan domain.SyncPlan) error {
+	if bundleRemotePath != "" {
+		cmd := fmt.Sprintf("tar -xzf %s -C %s", bundleRemotePath, targetDir)
+		if err := s.exec.Exec(cmd); err != nil {
+			return fmt.Errorf("extract bundle error: %s", err)
+		}
+	}
+	for _, f := range plan.Delete {
+		fmt.Fprintln(s.out, i18n.Msgf(i18n.MsgRemoving, s.lang, f))
+		if err := s.fs.Remove(fmt.Sprintf("%s/%s", targetDir, f)); err != nil {
+			return fmt.Errorf("remove file error: %s", err)
+		}
+	}
+	fmt.Fprintln(s.out, i18n.Msgf(i18n.MsgSynced, s.lang, len(plan.Upload), len(plan.Delete)))
+
+	if err := s.SaveManifest(targetDir, bundleName, local); err != nil {
+		return err
+	}
+
+	snap, err := s.snapshot.Read(targetDir)
+	if err != nil {
+		return fmt.Errorf("read snapshot error: %s", err)
+	}
+	if snap == nil {
+		snap = &domain.Snapshot{}
+	}
+	modTime, mtErr := s.fs.FileModTime(fmt.Sprintf("%s/%s", targetDir, sourceName))
+	if mtErr != nil {
+		modTime = time.Now().UTC()
+	}
+	// the manifest holds the hashes of the synced directory
+	tree := map[string]string{}
+	for p, hash := range local {
+		tree[strings.TrimPrefix(p, sourceName+"/")] = hash
+	}
+	snap.AddEntry(domain.NewSnapshotEntry(bundleName, domain.ManifestHash(local), []domain.FileRecord{
+		{Path: sourceName, ModTime: modTime, Tree: tree},
+	}))
+	if err = s.snapshot.Write(targetDir, snap); err != nil {
+		return fmt.Errorf("write snapshot error: %s", err)
+	}
+	fmt.Fprintln(s.out, i18n.Msg(i18n.MsgSnapshotUpdated, s.lang))
+	return nil
+}
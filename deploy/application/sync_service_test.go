@@ -0,0 +1,76 @@
+package application
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/koyeo/cast/deploy/domain"
+)
+
+type mockManifestRepo struct {
+	manifests map[string]*domain.Manifest
+}
+
+func newMockManifestRepo() *mockManifestRepo {
+	return &mockManifestRepo{manifests: make(map[string]*domain.Manifest)}
+}
+
+func (m *mockManifestRepo) Read(targetDir, bundleName string) (*domain.Manifest, error) {
+	return m.manifests[targetDir+"/"+bundleName], nil
+}
+
+func (m *mockManifestRepo) Write(targetDir, bundleName string, manifest *domain.Manifest) error {
+	m.manifests[targetDir+"/"+bundleName] = manifest
+	return nil
+}
+
+func TestSync_PlanWithoutManifest(t *testing.T) {
+	svc := NewSyncService(newMockFS(), newMockExec(), newMockSnapshotRepo(), newMockManifestRepo(), "en")
+	_, manifest, err := svc.Plan("/target", "site.tar.gz", map[string]string{"site/a.css": "a"}, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if manifest != nil {
+		t.Error("expected nil manifest before the first sync")
+	}
+}
+
+func TestSync_Apply(t *testing.T) {
+	mockFS := newMockFS()
+	mockExec := newMockExec()
+	mockRepo := newMockSnapshotRepo()
+	manifests := newMockManifestRepo()
+	mockFS.files["/target/site/gone.txt"] = []byte("gone")
+	manifests.manifests["/target/site.tar.gz"] = &domain.Manifest{Files: []domain.FileRecord{
+		{Path: "site/a.css", Hash: "old"},
+		{Path: "site/gone.txt", Hash: "g"},
+	}}
+
+	svc := NewSyncService(mockFS, mockExec, mockRepo, manifests, "en")
+	local := map[string]string{"site/a.css": "new"}
+	plan, _, err := svc.Plan("/target", "site.tar.gz", local, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err = svc.Apply("/target/bundle-site.tar.gz~", "/target", "site.tar.gz", "site", local, plan); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(mockExec.commands) != 1 || !strings.Contains(mockExec.commands[0], "tar -xzf /target/bundle-site.tar.gz~ -C /target") {
+		t.Errorf("expected bundle extracted over target, got %v", mockExec.commands)
+	}
+	if _, ok := mockFS.files["/target/site/gone.txt"]; ok {
+		t.Error("expected deleted file to be removed")
+	}
+	manifest := manifests.manifests["/target/site.tar.gz"]
+	if len(manifest.Files) != 1 || manifest.Files[0].Hash != "new" {
+		t.Errorf("expected manifest to be updated, got %+v", manifest)
+	}
+	snap := mockRepo.snapshots["/target"]
+	if snap == nil || len(snap.Entries) != 1 || snap.Entries[0].Files[0].Path != "site" {
+		t.Fatalf("expected snapshot entry managing 'site', got %+v", snap)
+	}
+	if snap.Entries[0].BundleHash != domain.ManifestHash(local) {
+		t.Error("expected bundle hash to be the manifest hash")
+	}
+}
@@ -0,0 +1,134 @@
+package application
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/koyeo/cast/deploy/domain"
+)
+
+// VerifyService compares deployed files against the latest snapshot entry.
+type VerifyService struct {
+	fs       domain.RemoteFS
+	snapshot domain.SnapshotRepository
+}
+
+// NewVerifyService creates a new VerifyService with all dependencies injected.
+func NewVerifyService(fs domain.RemoteFS, snapshot domain.SnapshotRepository) *VerifyService {
+	return &VerifyService{
+		fs:       fs,
+		snapshot: snapshot,
+	}
+}
+
+// Verify recomputes the hash of every file of the latest entry of
+// bundleName in targetDir, and looks for unmanaged files created after
+// that deploy.
+func (s *VerifyService) Verify(targetDir, bundleName string) (*domain.DriftReport, error) {
+	snap, err := s.snapshot.Read(targetDir)
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot error: %s", err)
+	}
+	latest := snap.LatestEntry(bundleName)
+	if latest < 0 {
+		return nil, fmt.Errorf("no deploy of %s recorded in %s", bundleName, targetDir)
+	}
+	entry := snap.Entries[latest]
+	dir := entry.Dir(targetDir)
+
+	report := &domain.DriftReport{}
+	for _, f := range entry.Files {
+		filePath := fmt.Sprintf("%s/%s", dir, f.Path)
+		info, statErr := s.fs.Stat(filePath)
+		if statErr != nil {
+			report.Deleted = append(report.Deleted, f.Path)
+			continue
+		}
+		if f.Tree != nil {
+			if err = s.verifyTree(report, filePath, f); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// Directories deployed before their files were recorded
+		if f.IsLegacy() && info.IsDir() {
+			continue
+		}
+		hash, hashErr := s.fs.FileHash(filePath)
+		if hashErr != nil {
+			return nil, fmt.Errorf("hash %s error: %s", filePath, hashErr)
+		}
+		if hash != f.Hash {
+			report.Modified = append(report.Modified, f.Path)
+		}
+	}
+
+	names, err := s.fs.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("list target dir error: %s", err)
+	}
+	for _, name := range names {
+		if entry.Release == "" && snap.IsManaged(name) {
+			continue
+		}
+		if entry.Release != "" && isEntryFile(entry, name) {
+			continue
+		}
+		if entry.Release == "" && (name == domain.ReleasesDir || name == domain.CurrentLink) {
+			continue
+		}
+		info, statErr := s.fs.Stat(fmt.Sprintf("%s/%s", dir, name))
+		if statErr == nil && info.ModTime().After(entry.DeployedAt) {
+			report.Added = append(report.Added, name)
+		}
+	}
+	return report, nil
+}
+
+// verifyTree compares the files under the directory of record f with
+// the hashes recorded for them.
+func (s *VerifyService) verifyTree(report *domain.DriftReport, dirPath string, f domain.FileRecord) error {
+	info, err := s.fs.Stat(dirPath)
+	if err != nil || !info.IsDir() {
+		report.Modified = append(report.Modified, f.Path)
+		return nil
+	}
+	live, err := s.fs.TreeHashes(dirPath)
+	if err != nil {
+		return fmt.Errorf("hash %s error: %s", dirPath, err)
+	}
+	for _, name := range sortedNames(f.Tree) {
+		hash, ok := live[name]
+		switch {
+		case !ok:
+			report.Deleted = append(report.Deleted, path.Join(f.Path, name))
+		case hash != f.Tree[name]:
+			report.Modified = append(report.Modified, path.Join(f.Path, name))
+		}
+	}
+	for _, name := range sortedNames(live) {
+		if _, ok := f.Tree[name]; !ok {
+			report.Added = append(report.Added, path.Join(f.Path, name))
+		}
+	}
+	return nil
+}
+
+func sortedNames(hashes map[string]string) []string {
+	names := make([]string, 0, len(hashes))
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isEntryFile(entry domain.SnapshotEntry, name string) bool {
+	for _, f := range entry.Files {
+		if f.Path == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package application
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koyeo/cast/deploy/domain"
+)
+
+func TestVerify_NoDrift(t *testing.T) {
+	mockFS := newMockFS()
+	mockRepo := newMockSnapshotRepo()
+	mockFS.files["/target/app.js"] = []byte("v1")
+	mockRepo.snapshots["/target"] = &domain.Snapshot{
+		Entries: []domain.SnapshotEntry{
+			{BundleName: "app.tar.gz", DeployedAt: time.Now().Add(time.Hour), Files: []domain.FileRecord{{Path: "app.js", Hash: "mockhash"}}},
+		},
+	}
+
+	report, err := NewVerifyService(mockFS, mockRepo).Verify("/target", "app.tar.gz")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if report.HasDrift() {
+		t.Errorf("expected no drift, got %+v", report)
+	}
+}
+
+func TestVerify_Drift(t *testing.T) {
+	mockFS := newMockFS()
+	mockRepo := newMockSnapshotRepo()
+	mockFS.files["/target/app.js"] = []byte("edited")
+	mockFS.files["/target/hack.sh"] = []byte("by hand")
+	mockRepo.snapshots["/target"] = &domain.Snapshot{
+		Entries: []domain.SnapshotEntry{
+			{
+				BundleName: "app.tar.gz",
+				DeployedAt: time.Now().Add(-time.Hour),
+				Files: []domain.FileRecord{
+					{Path: "app.js", Hash: "deployedhash"},
+					{Path: "lib.js", Hash: "mockhash"},
+				},
+			},
+		},
+	}
+
+	report, err := NewVerifyService(mockFS, mockRepo).Verify("/target", "app.tar.gz")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(report.Modified) != 1 || report.Modified[0] != "app.js" {
+		t.Errorf("expected modified [app.js], got %v", report.Modified)
+	}
+	if len(report.Deleted) != 1 || report.Deleted[0] != "lib.js" {
+		t.Errorf("expected deleted [lib.js], got %v", report.Deleted)
+	}
+	if len(report.Added) != 1 || report.Added[0] != "hack.sh" {
+		t.Errorf("expected added [hack.sh], got %v", report.Added)
+	}
+}
+
+func TestVerify_Directory(t *testing.T) {
+	mockFS := newMockFS()
+	mockRepo := newMockSnapshotRepo()
+	mockFS.dirs["/target/dist"] = true
+	mockFS.files["/target/dist/index.html"] = []byte("edited")
+	mockFS.files["/target/dist/js/app.js"] = []byte("app")
+	mockFS.files["/target/dist/js/hack.js"] = []byte("by hand")
+	mockRepo.snapshots["/target"] = &domain.Snapshot{
+		Entries: []domain.SnapshotEntry{
+			{
+				BundleName: "dist.tar.gz",
+				DeployedAt: time.Now().Add(time.Hour),
+				Files: []domain.FileRecord{{Path: "dist", Tree: map[string]string{
+					"index.html": "deployed",
+					"js/app.js":  "app",
+					"js/lib.js":  "lib",
+				}}},
+			},
+		},
+	}
+
+	report, err := NewVerifyService(mockFS, mockRepo).Verify("/target", "dist.tar.gz")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(report.Modified) != 1 || report.Modified[0] != "dist/index.html" {
+		t.Errorf("expected modified [dist/index.html], got %v", report.Modified)
+	}
+	if len(report.Deleted) != 1 || report.Deleted[0] != "dist/js/lib.js" {
+		t.Errorf("expected deleted [dist/js/lib.js], got %v", report.Deleted)
+	}
+	if len(report.Added) != 1 || report.Added[0] != "dist/js/hack.js" {
+		t.Errorf("expected added [dist/js/hack.js], got %v", report.Added)
+	}
+
+	// a modified file inside the directory means the upload can't be skipped
+	unchanged, err := setupService(mockFS, newMockExec(), mockRepo, nil).Unchanged("/target", "dist.tar.gz", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if unchanged {
+		t.Error("expected a drifted directory not to count as unchanged")
+	}
+}
+
+func TestVerify_NoSnapshot(t *testing.T) {
+	_, err := NewVerifyService(newMockFS(), newMockSnapshotRepo()).Verify("/target", "app.tar.gz")
+	if err == nil {
+		t.Error("expected error when nothing was deployed")
+	}
+}
+
+func TestVerify_LegacyRecords(t *testing.T) {
+	mockFS := newMockFS()
+	mockRepo := newMockSnapshotRepo()
+	mockFS.dirs["/target/dist"] = true
+	mockFS.files["/target/dist/index.html"] = []byte("index")
+	mockFS.files["/target/app.js"] = []byte("v1")
+	mockRepo.snapshots["/target"] = &domain.Snapshot{
+		Entries: []domain.SnapshotEntry{
+			{
+				BundleName: "app.tar.gz",
+				DeployedAt: time.Now().Add(time.Hour),
+				Files:      []domain.FileRecord{{Path: "dist"}, {Path: "app.js"}},
+			},
+		},
+	}
+
+	report, err := NewVerifyService(mockFS, mockRepo).Verify("/target", "app.tar.gz")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// a file without a hash cannot be confirmed clean
+	if len(report.Modified) != 1 || report.Modified[0] != "app.js" {
+		t.Errorf("expected modified [app.js], got %v", report.Modified)
+	}
+	if len(report.Deleted) != 0 || len(report.Added) != 0 {
+		t.Errorf("expected the legacy directory skipped, got %+v", report)
+	}
+}
+
+// failingHashFS fails to hash any file, as a host without sha256sum and
+// shasum does.
+type failingHashFS struct {
+	*mockRemoteFS
+}
+
+func (m *failingHashFS) FileHash(path string) (string, error) {
+	return "", fmt.Errorf("compute hash error: exit status 127")
+}
+
+func (m *failingHashFS) TreeHashes(dir string) (map[string]string, error) {
+	return nil, fmt.Errorf("compute hashes of %s error: exit status 127", dir)
+}
+
+func TestRecordFile(t *testing.T) {
+	mockFS := newMockFS()
+	mockFS.dirs["/target/empty"] = true
+	record, err := recordFile(mockFS, "/target", "empty")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if record.Tree == nil || record.IsLegacy() {
+		t.Errorf("expected an empty tree for an empty directory, got %+v", record)
+	}
+
+	failing := &failingHashFS{mockRemoteFS: mockFS}
+	mockFS.files["/target/app.js"] = []byte("v1")
+	for _, f := range []string{"app.js", "empty"} {
+		if _, err = recordFile(failing, "/target", f); err == nil || !strings.Contains(err.Error(), "hash /target/"+f) {
+			t.Errorf("expected hash error for %s, got %v", f, err)
+		}
+	}
+}
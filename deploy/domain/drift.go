@@ -0,0 +1,17 @@
+package domain
+
+// DriftReport lists differences between the files on a server and the
+// snapshot entry that deployed them.
+type DriftReport struct {
+	// Modified are managed files whose hash no longer matches the snapshot.
+	Modified []string `json:"modified"`
+	// Deleted are managed files missing from the server.
+	Deleted []string `json:"deleted"`
+	// Added are unmanaged files created after the deploy.
+	Added []string `json:"added"`
+}
+
+// HasDrift reports whether any file differs from the snapshot.
+func (r *DriftReport) HasDrift() bool {
+	return len(r.Modified)+len(r.Deleted)+len(r.Added) > 0
+}
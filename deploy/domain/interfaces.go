@@ -26,12 +26,19 @@ type RemoteFS interface {
 	ReadFile(path string) ([]byte, error)
 	// WriteFile writes content to a file, creating it if necessary.
 	WriteFile(path string, data []byte) error
+	// CreateFile writes content to a new file, failing if it already exists.
+	CreateFile(path string, data []byte) error
 	// Remove removes a file or directory recursively.
 	Remove(path string) error
 	// Rename moves/renames a file or directory.
 	Rename(src, dst string) error
+	// Replace atomically renames src over dst, replacing dst if it exists.
+	Replace(src, dst string) error
 	// FileHash computes SHA256 hash of a remote file.
 	FileHash(path string) (string, error)
+	// TreeHashes computes the SHA256 hash of every file under a directory,
+	// keyed by slash separated path relative to it.
+	TreeHashes(dir string) (map[string]string, error)
 	// FileModTime returns the modification time of a file.
 	FileModTime(path string) (time.Time, error)
 }
@@ -59,3 +66,13 @@ type SnapshotRepository interface {
 	// Write persists the snapshot to the target directory.
 	Write(targetDir string, snapshot *Snapshot) error
 }
+
+// ManifestRepository abstracts reading/writing the file manifest of a
+// directory synced with mode: sync.
+type ManifestRepository interface {
+	// Read returns the manifest of bundleName in the target directory.
+	// Returns nil (no error) if no manifest exists.
+	Read(targetDir, bundleName string) (*Manifest, error)
+	// Write persists the manifest of bundleName to the target directory.
+	Write(targetDir, bundleName string, manifest *Manifest) error
+}
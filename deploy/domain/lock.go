@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// LockFileName is the deploy lock of a target directory, relative to it.
+const LockFileName = ".cast/deploy.lock"
+
+// Lock records who is deploying to a target directory.
+type Lock struct {
+	Holder     string    `json:"holder"`
+	Host       string    `json:"host"`
+	PID        int       `json:"pid"`
+	AcquiredAt time.Time `json:"acquired_at"`
+}
+
+// Age returns how long the lock has been held at now.
+func (l *Lock) Age(now time.Time) time.Duration {
+	return now.Sub(l.AcquiredAt)
+}
+
+// SameHolder reports whether o was taken by the same process as l.
+func (l *Lock) SameHolder(o *Lock) bool {
+	return o != nil && l.Holder == o.Holder && l.Host == o.Host && l.PID == o.PID && l.AcquiredAt.Equal(o.AcquiredAt)
+}
+
+func (l *Lock) String() string {
+	return fmt.Sprintf("%s@%s (pid %d) since %s", l.Holder, l.Host, l.PID, l.AcquiredAt.Local().Format("2006-01-02 15:04:05"))
+}
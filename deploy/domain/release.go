@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// ReleasesDir holds one directory per deploy in the releases layout.
+	ReleasesDir = "releases"
+	// CurrentLink is the symlink pointing at the live release.
+	CurrentLink = "current"
+	// DefaultKeepReleases is the number of releases kept when unset.
+	DefaultKeepReleases = 5
+)
+
+// NextReleaseName returns a release directory name for a deploy at t,
+// sortable by time. A sequence number is appended when the name is taken,
+// e.g. two deploys within the same second.
+func NextReleaseName(t time.Time, exists func(string) bool) string {
+	name := t.UTC().Format("20060102T150405Z")
+	candidate := name
+	for i := 2; exists(candidate) && i < 100000; i++ {
+		candidate = fmt.Sprintf("%s.%d", name, i)
+	}
+	return candidate
+}
+
+// ReleasesToPrune returns the oldest releases beyond the newest keep ones.
+// The live release is never pruned.
+func ReleasesToPrune(releases []string, current string, keep int) []string {
+	if keep <= 0 {
+		keep = DefaultKeepReleases
+	}
+	sorted := append([]string(nil), releases...)
+	sort.Strings(sorted)
+	var prune []string
+	for i := 0; i < len(sorted)-keep; i++ {
+		if sorted[i] != current {
+			prune = append(prune, sorted[i])
+		}
+	}
+	return prune
+}
@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextReleaseName(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 20, 30, 0, time.UTC)
+	name := NextReleaseName(at, func(string) bool { return false })
+	if name != "20240501T102030Z" {
+		t.Errorf("expected '20240501T102030Z', got '%s'", name)
+	}
+	taken := map[string]bool{"20240501T102030Z": true}
+	name = NextReleaseName(at, func(n string) bool { return taken[n] })
+	if name != "20240501T102030Z.2" {
+		t.Errorf("expected '20240501T102030Z.2', got '%s'", name)
+	}
+}
+
+func TestReleasesToPrune(t *testing.T) {
+	releases := []string{"r3", "r1", "r4", "r2", "r5"}
+	prune := ReleasesToPrune(releases, "r5", 3)
+	if len(prune) != 2 || prune[0] != "r1" || prune[1] != "r2" {
+		t.Errorf("expected [r1 r2], got %v", prune)
+	}
+}
+
+func TestReleasesToPrune_KeepsCurrent(t *testing.T) {
+	// After a rollback the live release can be an old one
+	prune := ReleasesToPrune([]string{"r1", "r2", "r3"}, "r1", 1)
+	if len(prune) != 1 || prune[0] != "r2" {
+		t.Errorf("expected [r2], got %v", prune)
+	}
+}
+
+func TestReleasesToPrune_Default(t *testing.T) {
+	prune := ReleasesToPrune([]string{"r1", "r2", "r3"}, "r3", 0)
+	if len(prune) != 0 {
+		t.Errorf("expected nothing pruned below the default, got %v", prune)
+	}
+}
@@ -1,6 +1,14 @@
 package domain
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
 
 // Snapshot is the aggregate root representing deployment metadata
 // stored at .cast/snapshot.json on the remote server.
@@ -10,10 +18,16 @@ type Snapshot struct {
 
 // SnapshotEntry records a single deployment event.
 type SnapshotEntry struct {
+	ID         string       `json:"id,omitempty"`
 	BundleName string       `json:"bundle_name"`
 	BundleHash string       `json:"bundle_hash"`
 	DeployedAt time.Time    `json:"deployed_at"`
 	Files      []FileRecord `json:"files"`
+	// RollbackOf is the ID of the entry restored by a rollback.
+	RollbackOf string `json:"rollback_of,omitempty"`
+	// Release is the release directory, relative to the target directory,
+	// of deploys using the releases layout. Files are relative to it.
+	Release string `json:"release,omitempty"`
 }
 
 // FileRecord is a value object storing metadata for a deployed file.
@@ -21,6 +35,44 @@ type FileRecord struct {
 	Path    string    `json:"path"`
 	Hash    string    `json:"hash"`
 	ModTime time.Time `json:"mod_time"`
+	// Tree holds the hashes of the files of a directory, by slash
+	// separated path relative to it. Directories have no Hash, an empty
+	// directory has an empty Tree.
+	Tree map[string]string `json:"tree,omitempty"`
+}
+
+// MarshalJSON keeps the empty Tree of an empty directory, which
+// omitempty would drop.
+func (f FileRecord) MarshalJSON() ([]byte, error) {
+	type record FileRecord
+	if f.Tree == nil || len(f.Tree) > 0 {
+		return json.Marshal(record(f))
+	}
+	return json.Marshal(struct {
+		record
+		Tree map[string]string `json:"tree"`
+	}{record(f), f.Tree})
+}
+
+// IsLegacy reports a record with neither a hash nor a tree, written for
+// directories before their files were recorded.
+func (f FileRecord) IsLegacy() bool {
+	return f.Hash == "" && f.Tree == nil
+}
+
+// TreeHash combines the hashes of the files of a directory record into
+// one hash.
+func (f FileRecord) TreeHash() string {
+	names := make([]string, 0, len(f.Tree))
+	for name := range f.Tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s %s\n", f.Tree[name], name)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
 // IsManaged checks if a filename appears in any entry of the snapshot.
@@ -38,11 +90,144 @@ func (s *Snapshot) IsManaged(filename string) bool {
 	return false
 }
 
-// AddEntry appends a new deployment entry to the snapshot.
+// DefaultKeepHistory is the number of deploys of a bundle whose replaced
+// files are kept in history when unset.
+const DefaultKeepHistory = 5
+
+// AddEntry appends a new deployment entry to the snapshot,
+// assigning the next sequence ID if the entry has none.
 func (s *Snapshot) AddEntry(entry SnapshotEntry) {
+	if entry.ID == "" {
+		// entries may have been pruned, so the count isn't the last ID
+		last := 0
+		for i := range s.Entries {
+			if id, err := strconv.Atoi(s.EntryID(i)); err == nil && id > last {
+				last = id
+			}
+		}
+		entry.ID = strconv.Itoa(last + 1)
+	}
 	s.Entries = append(s.Entries, entry)
 }
 
+// EntriesToPrune returns the indexes of the entries of bundleName older
+// than its newest keep ones. Entries the live copy of a file still
+// belongs to are kept, as they own it.
+func (s *Snapshot) EntriesToPrune(bundleName string, keep int) []int {
+	if s == nil {
+		return nil
+	}
+	if keep <= 0 {
+		keep = DefaultKeepHistory
+	}
+	var prune []int
+	newer := 0
+	for i := len(s.Entries) - 1; i >= 0; i-- {
+		if s.Entries[i].BundleName != bundleName {
+			continue
+		}
+		if newer++; newer <= keep || s.ownsFile(i) {
+			continue
+		}
+		prune = append([]int{i}, prune...)
+	}
+	return prune
+}
+
+func (s *Snapshot) ownsFile(i int) bool {
+	id := s.EntryID(i)
+	for _, f := range s.Entries[i].Files {
+		if s.Owner(f.Path) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveEntries removes the entries at the given indexes. The IDs of the
+// remaining entries are kept, including those of entries written before
+// IDs existed.
+func (s *Snapshot) RemoveEntries(indexes []int) {
+	removed := map[int]bool{}
+	for _, i := range indexes {
+		removed[i] = true
+	}
+	kept := make([]SnapshotEntry, 0, len(s.Entries))
+	for i, entry := range s.Entries {
+		if removed[i] {
+			continue
+		}
+		entry.ID = s.EntryID(i)
+		kept = append(kept, entry)
+	}
+	s.Entries = kept
+}
+
+// EntryID returns the ID of the entry at index i. Entries written before
+// IDs existed are identified by their 1-based position.
+func (s *Snapshot) EntryID(i int) string {
+	if s.Entries[i].ID != "" {
+		return s.Entries[i].ID
+	}
+	return strconv.Itoa(i + 1)
+}
+
+// FindEntry returns the index of the entry with the given ID, or -1.
+func (s *Snapshot) FindEntry(id string) int {
+	if s == nil {
+		return -1
+	}
+	for i := range s.Entries {
+		if s.EntryID(i) == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// LatestEntry returns the index of the most recent entry for bundleName,
+// or of the most recent entry overall if bundleName is empty; -1 if none.
+func (s *Snapshot) LatestEntry(bundleName string) int {
+	if s == nil {
+		return -1
+	}
+	return s.previousEntry(len(s.Entries), bundleName)
+}
+
+// PreviousEntry returns the index of the last entry before index i that
+// shares bundleName (any bundle if empty), or -1.
+func (s *Snapshot) PreviousEntry(i int, bundleName string) int {
+	if s == nil {
+		return -1
+	}
+	return s.previousEntry(i, bundleName)
+}
+
+func (s *Snapshot) previousEntry(i int, bundleName string) int {
+	for j := i - 1; j >= 0; j-- {
+		if bundleName == "" || s.Entries[j].BundleName == bundleName {
+			return j
+		}
+	}
+	return -1
+}
+
+// Owner returns the ID of the latest entry that deployed filename, that is
+// the entry the live copy on the server belongs to. Empty if unmanaged.
+func (s *Snapshot) Owner(filename string) string {
+	if s == nil {
+		return ""
+	}
+	for i := len(s.Entries) - 1; i >= 0; i-- {
+		for _, f := range s.Entries[i].Files {
+			if f.Path == filename {
+				return s.EntryID(i)
+			}
+		}
+	}
+	return ""
+}
+
 // NewSnapshotEntry creates a new SnapshotEntry with the current time.
 func NewSnapshotEntry(bundleName, bundleHash string, files []FileRecord) SnapshotEntry {
 	return SnapshotEntry{
@@ -52,3 +237,74 @@ func NewSnapshotEntry(bundleName, bundleHash string, files []FileRecord) Snapsho
 		Files:      files,
 	}
 }
+
+// EntryDiff lists file differences between two snapshot entries.
+type EntryDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// DiffEntries compares the file sets and hashes of two entries,
+// reporting what changed going from `from` to `to`. Changes inside a
+// directory present in both are listed by the paths of its files.
+func DiffEntries(from, to SnapshotEntry) EntryDiff {
+	diff := EntryDiff{}
+	before := map[string]FileRecord{}
+	for _, f := range from.Files {
+		before[f.Path] = f
+	}
+	after := map[string]bool{}
+	for _, f := range to.Files {
+		after[f.Path] = true
+		old, ok := before[f.Path]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, f.Path)
+		case old.Tree != nil && f.Tree != nil:
+			diffTrees(&diff, f.Path, old.Tree, f.Tree)
+		case old.Hash != f.Hash || (old.Tree == nil) != (f.Tree == nil):
+			diff.Changed = append(diff.Changed, f.Path)
+		}
+	}
+	for _, f := range from.Files {
+		if !after[f.Path] {
+			diff.Removed = append(diff.Removed, f.Path)
+		}
+	}
+	return diff
+}
+
+// diffTrees adds the changes between two trees of directory dir to diff.
+func diffTrees(diff *EntryDiff, dir string, from, to map[string]string) {
+	names := make([]string, 0, len(from)+len(to))
+	for name := range from {
+		names = append(names, name)
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		before, inFrom := from[name]
+		after, inTo := to[name]
+		switch {
+		case !inFrom:
+			diff.Added = append(diff.Added, path.Join(dir, name))
+		case !inTo:
+			diff.Removed = append(diff.Removed, path.Join(dir, name))
+		case before != after:
+			diff.Changed = append(diff.Changed, path.Join(dir, name))
+		}
+	}
+}
+
+// Dir returns the directory the entry's files are relative to.
+func (e SnapshotEntry) Dir(targetDir string) string {
+	if e.Release != "" {
+		return fmt.Sprintf("%s/%s", targetDir, e.Release)
+	}
+	return targetDir
+}
@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 )
@@ -70,3 +71,185 @@ func TestAddEntry(t *testing.T) {
 		t.Errorf("expected 1 file, got %d", len(s.Entries[0].Files))
 	}
 }
+
+func TestAddEntry_AssignsID(t *testing.T) {
+	s := &Snapshot{Entries: []SnapshotEntry{{}}}
+	s.AddEntry(NewSnapshotEntry("app.tar.gz", "abc", nil))
+	if s.Entries[1].ID != "2" {
+		t.Errorf("expected id '2', got '%s'", s.Entries[1].ID)
+	}
+	if s.EntryID(0) != "1" {
+		t.Errorf("expected legacy entry id '1', got '%s'", s.EntryID(0))
+	}
+}
+
+func TestOwner_LatestEntry(t *testing.T) {
+	s := &Snapshot{
+		Entries: []SnapshotEntry{
+			{ID: "1", Files: []FileRecord{{Path: "app.js"}, {Path: "lib.js"}}},
+			{ID: "2", Files: []FileRecord{{Path: "app.js"}}},
+		},
+	}
+	if s.Owner("app.js") != "2" {
+		t.Errorf("expected app.js owned by '2', got '%s'", s.Owner("app.js"))
+	}
+	if s.Owner("lib.js") != "1" {
+		t.Errorf("expected lib.js owned by '1', got '%s'", s.Owner("lib.js"))
+	}
+	if s.Owner("other.js") != "" {
+		t.Error("expected unmanaged file to have no owner")
+	}
+}
+
+func TestPreviousEntry_SameBundle(t *testing.T) {
+	s := &Snapshot{
+		Entries: []SnapshotEntry{
+			{BundleName: "a.tar.gz"},
+			{BundleName: "b.tar.gz"},
+			{BundleName: "a.tar.gz"},
+		},
+	}
+	latest := s.LatestEntry("a.tar.gz")
+	if latest != 2 {
+		t.Fatalf("expected latest index 2, got %d", latest)
+	}
+	if prev := s.PreviousEntry(latest, "a.tar.gz"); prev != 0 {
+		t.Errorf("expected previous index 0, got %d", prev)
+	}
+	if prev := s.PreviousEntry(latest, ""); prev != 1 {
+		t.Errorf("expected previous index 1 for any bundle, got %d", prev)
+	}
+	if s.FindEntry("3") != 2 {
+		t.Errorf("expected entry '3' at index 2")
+	}
+}
+
+func TestDiffEntries(t *testing.T) {
+	from := SnapshotEntry{Files: []FileRecord{
+		{Path: "app.js", Hash: "a1"},
+		{Path: "old.js", Hash: "o1"},
+		{Path: "same.js", Hash: "s1"},
+	}}
+	to := SnapshotEntry{Files: []FileRecord{
+		{Path: "app.js", Hash: "a2"},
+		{Path: "new.js", Hash: "n1"},
+		{Path: "same.js", Hash: "s1"},
+	}}
+	diff := DiffEntries(from, to)
+	if len(diff.Added) != 1 || diff.Added[0] != "new.js" {
+		t.Errorf("expected added [new.js], got %v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0] != "old.js" {
+		t.Errorf("expected removed [old.js], got %v", diff.Removed)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0] != "app.js" {
+		t.Errorf("expected changed [app.js], got %v", diff.Changed)
+	}
+}
+
+func TestEntriesToPrune(t *testing.T) {
+	files := func(paths ...string) []FileRecord {
+		var records []FileRecord
+		for _, p := range paths {
+			records = append(records, FileRecord{Path: p})
+		}
+		return records
+	}
+	s := &Snapshot{Entries: []SnapshotEntry{
+		{ID: "1", BundleName: "app", Files: files("app.js", "legacy.js")},
+		{ID: "2", BundleName: "app", Files: files("app.js")},
+		{ID: "3", BundleName: "web", Files: files("index.html")},
+		{ID: "4", BundleName: "app", Files: files("app.js")},
+		{ID: "5", BundleName: "app", Files: files("app.js")},
+	}}
+	// 1 still owns legacy.js, the entries of web aren't counted
+	prune := s.EntriesToPrune("app", 2)
+	if len(prune) != 1 || prune[0] != 1 {
+		t.Fatalf("expected entry 2 pruned, got indexes %v", prune)
+	}
+	if got := s.EntriesToPrune("app", 0); len(got) != 0 {
+		t.Errorf("expected the default keep of 5 to prune nothing, got %v", got)
+	}
+
+	s.RemoveEntries(prune)
+	var ids []string
+	for i := range s.Entries {
+		ids = append(ids, s.EntryID(i))
+	}
+	if len(ids) != 4 || ids[1] != "3" || ids[3] != "5" {
+		t.Errorf("expected entries 1, 3, 4, 5 kept, got %v", ids)
+	}
+	s.AddEntry(SnapshotEntry{BundleName: "app"})
+	if id := s.Entries[len(s.Entries)-1].ID; id != "6" {
+		t.Errorf("expected the next ID 6, got %s", id)
+	}
+}
+
+func TestRemoveEntries_KeepsPositionalIDs(t *testing.T) {
+	// entries written before IDs existed are identified by position
+	s := &Snapshot{Entries: []SnapshotEntry{{BundleName: "app"}, {BundleName: "app"}, {BundleName: "app"}}}
+	s.RemoveEntries([]int{0})
+	if s.EntryID(0) != "2" || s.EntryID(1) != "3" {
+		t.Errorf("expected IDs 2 and 3, got %s and %s", s.EntryID(0), s.EntryID(1))
+	}
+}
+
+func TestDiffEntries_Tree(t *testing.T) {
+	from := SnapshotEntry{Files: []FileRecord{
+		{Path: "dist", Tree: map[string]string{"index.html": "i1", "js/app.js": "a1", "js/old.js": "o1"}},
+		{Path: "empty", Tree: map[string]string{}},
+	}}
+	to := SnapshotEntry{Files: []FileRecord{
+		{Path: "dist", Tree: map[string]string{"index.html": "i1", "js/app.js": "a2", "js/new.js": "n1"}},
+		{Path: "empty", Hash: "e1"},
+	}}
+	diff := DiffEntries(from, to)
+	if len(diff.Added) != 1 || diff.Added[0] != "dist/js/new.js" {
+		t.Errorf("expected added [dist/js/new.js], got %v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0] != "dist/js/old.js" {
+		t.Errorf("expected removed [dist/js/old.js], got %v", diff.Removed)
+	}
+	if len(diff.Changed) != 2 || diff.Changed[0] != "dist/js/app.js" || diff.Changed[1] != "empty" {
+		t.Errorf("expected changed [dist/js/app.js empty], got %v", diff.Changed)
+	}
+}
+
+func TestFileRecord_JSON(t *testing.T) {
+	data, err := json.Marshal([]FileRecord{
+		{Path: "app.js", Hash: "a1"},
+		{Path: "empty", Tree: map[string]string{}},
+		{Path: "dist", Tree: map[string]string{"index.html": "i1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []FileRecord
+	if err = json.Unmarshal(data, &records); err != nil {
+		t.Fatal(err)
+	}
+	if records[0].Tree != nil || records[0].IsLegacy() {
+		t.Errorf("expected a file record, got %+v", records[0])
+	}
+	if records[1].Tree == nil || len(records[1].Tree) != 0 {
+		t.Errorf("expected the empty tree kept, got %+v in %s", records[1], data)
+	}
+	if records[2].Tree["index.html"] != "i1" {
+		t.Errorf("expected the tree kept, got %+v", records[2])
+	}
+	if !(FileRecord{Path: "dist"}).IsLegacy() {
+		t.Error("expected a record without hash and tree legacy")
+	}
+}
+
+func TestFileRecord_TreeHash(t *testing.T) {
+	a := FileRecord{Tree: map[string]string{"a": "1", "b": "2"}}
+	b := FileRecord{Tree: map[string]string{"b": "2", "a": "1"}}
+	c := FileRecord{Tree: map[string]string{"a": "1", "b": "3"}}
+	if a.TreeHash() != b.TreeHash() {
+		t.Error("expected the tree hash independent of order")
+	}
+	if a.TreeHash() == c.TreeHash() {
+		t.Error("expected the tree hash to change with a file")
+	}
+}
@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+)
+
+// Manifest records the hash of every file synced from a directory mapper.
+// Paths are slash separated and relative to the target directory.
+type Manifest struct {
+	Files []FileRecord `json:"files"`
+}
+
+// SyncPlan lists the files an incremental sync has to transfer or delete.
+type SyncPlan struct {
+	Upload []string
+	Delete []string
+}
+
+// Empty reports whether the remote side is already in sync.
+func (p SyncPlan) Empty() bool {
+	return len(p.Upload) == 0 && len(p.Delete) == 0
+}
+
+// PlanSync compares local file hashes (path → hash) against the remote
+// manifest. Files missing or different remotely are uploaded; remote files
+// gone locally are deleted only if deleteExtra is set.
+func PlanSync(local map[string]string, remote *Manifest, deleteExtra bool) SyncPlan {
+	plan := SyncPlan{}
+	remoteHashes := map[string]string{}
+	if remote != nil {
+		for _, f := range remote.Files {
+			remoteHashes[f.Path] = f.Hash
+		}
+	}
+	for p, hash := range local {
+		if remoteHash, ok := remoteHashes[p]; !ok || remoteHash != hash {
+			plan.Upload = append(plan.Upload, p)
+		}
+	}
+	if deleteExtra {
+		for p := range remoteHashes {
+			if _, ok := local[p]; !ok {
+				plan.Delete = append(plan.Delete, p)
+			}
+		}
+	}
+	sort.Strings(plan.Upload)
+	sort.Strings(plan.Delete)
+	return plan
+}
+
+// ManifestHash returns a stable hash of a set of file hashes, used as the
+// bundle hash of a sync so unchanged directories can be recognized.
+func ManifestHash(files map[string]string) string {
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	h := sha256.New()
+	for _, p := range paths {
+		_, _ = fmt.Fprintf(h, "%s %s\n", files[p], p)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,54 @@
+package domain
+
+import "testing"
+
+func TestPlanSync_NoManifest(t *testing.T) {
+	plan := PlanSync(map[string]string{"site/a.css": "a", "site/b.js": "b"}, nil, true)
+	if len(plan.Upload) != 2 {
+		t.Errorf("expected 2 uploads, got %v", plan.Upload)
+	}
+	if len(plan.Delete) != 0 {
+		t.Errorf("expected no deletes, got %v", plan.Delete)
+	}
+}
+
+func TestPlanSync_Changed(t *testing.T) {
+	remote := &Manifest{Files: []FileRecord{
+		{Path: "site/a.css", Hash: "a"},
+		{Path: "site/b.js", Hash: "old"},
+		{Path: "site/gone.txt", Hash: "g"},
+	}}
+	local := map[string]string{"site/a.css": "a", "site/b.js": "b", "site/new.txt": "n"}
+
+	plan := PlanSync(local, remote, false)
+	if len(plan.Upload) != 2 || plan.Upload[0] != "site/b.js" || plan.Upload[1] != "site/new.txt" {
+		t.Errorf("expected uploads [site/b.js site/new.txt], got %v", plan.Upload)
+	}
+	if len(plan.Delete) != 0 {
+		t.Errorf("expected no deletes without delete flag, got %v", plan.Delete)
+	}
+
+	plan = PlanSync(local, remote, true)
+	if len(plan.Delete) != 1 || plan.Delete[0] != "site/gone.txt" {
+		t.Errorf("expected deletes [site/gone.txt], got %v", plan.Delete)
+	}
+}
+
+func TestPlanSync_InSync(t *testing.T) {
+	remote := &Manifest{Files: []FileRecord{{Path: "site/a.css", Hash: "a"}}}
+	plan := PlanSync(map[string]string{"site/a.css": "a"}, remote, true)
+	if !plan.Empty() {
+		t.Errorf("expected empty plan, got %+v", plan)
+	}
+}
+
+func TestManifestHash_Stable(t *testing.T) {
+	a := ManifestHash(map[string]string{"x": "1", "y": "2"})
+	b := ManifestHash(map[string]string{"y": "2", "x": "1"})
+	if a != b {
+		t.Error("expected hash to be independent of map order")
+	}
+	if a == ManifestHash(map[string]string{"x": "1", "y": "3"}) {
+		t.Error("expected hash to change with file content")
+	}
+}
@@ -0,0 +1,54 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/koyeo/cast/deploy/domain"
+)
+
+const manifestDirName = ".cast/manifests"
+
+// ManifestRepo implements domain.ManifestRepository using RemoteFS.
+type ManifestRepo struct {
+	fs domain.RemoteFS
+}
+
+// NewManifestRepo creates a new ManifestRepo.
+func NewManifestRepo(fs domain.RemoteFS) *ManifestRepo {
+	return &ManifestRepo{fs: fs}
+}
+
+func (r *ManifestRepo) path(targetDir, bundleName string) string {
+	return fmt.Sprintf("%s/%s/%s.json", targetDir, manifestDirName, bundleName)
+}
+
+func (r *ManifestRepo) Read(targetDir, bundleName string) (*domain.Manifest, error) {
+	manifestPath := r.path(targetDir, bundleName)
+	if _, err := r.fs.Stat(manifestPath); err != nil {
+		return nil, nil
+	}
+	data, err := r.fs.ReadFile(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest error: %s", err)
+	}
+	var manifest domain.Manifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("decode manifest error: %s", err)
+	}
+	return &manifest, nil
+}
+
+func (r *ManifestRepo) Write(targetDir, bundleName string, manifest *domain.Manifest) error {
+	if err := r.fs.MkdirAll(fmt.Sprintf("%s/%s", targetDir, manifestDirName)); err != nil {
+		return fmt.Errorf("create manifest dir error: %s", err)
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("marshal manifest error: %s", err)
+	}
+	if err = r.fs.WriteFile(r.path(targetDir, bundleName), data); err != nil {
+		return fmt.Errorf("write manifest error: %s", err)
+	}
+	return nil
+}
@@ -67,6 +67,14 @@ func (m *mockFS) WriteFile(path string, data []byte) error {
 	return nil
 }
 
+func (m *mockFS) CreateFile(path string, data []byte) error {
+	if _, ok := m.files[path]; ok {
+		return fmt.Errorf("exists: %s", path)
+	}
+	m.files[path] = data
+	return nil
+}
+
 func (m *mockFS) Remove(path string) error {
 	delete(m.files, path)
 	return nil
@@ -78,8 +86,13 @@ func (m *mockFS) Rename(src, dst string) error {
 	return nil
 }
 
+func (m *mockFS) Replace(src, dst string) error {
+	return m.Rename(src, dst)
+}
+
 func (m *mockFS) FileHash(path string) (string, error)           { return "mock", nil }
 func (m *mockFS) FileModTime(path string) (time.Time, error)     { return time.Now(), nil }
+func (m *mockFS) TreeHashes(dir string) (map[string]string, error) { return nil, nil }
 
 // --- Tests ---
 
@@ -0,0 +1,362 @@
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Authentication methods, tried in the order of SSHAuth.Methods.
+const (
+	// AuthAgent signs with the keys of the ssh-agent at $SSH_AUTH_SOCK.
+	AuthAgent = "agent"
+	// AuthPublicKey signs with the identity file, or the default keys
+	// in ~/.ssh, along with their certificates.
+	AuthPublicKey = "publickey"
+	// AuthPassword sends the password, asking for it if it is empty.
+	AuthPassword = "password"
+	// AuthKeyboardInteractive answers the questions of the server,
+	// e.g. a 2FA code, on the terminal.
+	AuthKeyboardInteractive = "keyboard-interactive"
+)
+
+// defaultIdentityFiles are tried by publickey without an identity file.
+var defaultIdentityFiles = []string{"~/.ssh/id_ed25519", "~/.ssh/id_ecdsa", "~/.ssh/id_rsa"}
+
+// Prompt asks the user a question, hiding the answer unless echo.
+type Prompt func(message string, echo bool) (string, error)
+
+// SSHAuth configures Keyring.AuthMethods.
+type SSHAuth struct {
+	// Name identifies the server in prompts.
+	Name string
+	// Methods lists the methods to try in order, a default chain when
+	// empty: agent, publickey, password if set, keyboard-interactive.
+	Methods         []string
+	Password        string
+	IdentityFile    string
+	CertificateFile string
+}
+
+// Keyring builds authentication methods, asking for key passphrases once
+// and keeping the decrypted keys and the ssh-agent connection for the run.
+type Keyring struct {
+	prompt  Prompt
+	out     io.Writer
+	mu      sync.Mutex
+	signers map[string]ssh.Signer
+
+	agentMu     sync.Mutex
+	agentConn   net.Conn
+	agent       agent.ExtendedAgent
+	agentErr    error
+	agentWarned bool
+}
+
+// NewKeyring creates a Keyring asking for passphrases and answers with prompt.
+func NewKeyring(prompt Prompt) *Keyring {
+	return &Keyring{prompt: prompt, out: os.Stderr, signers: map[string]ssh.Signer{}}
+}
+
+// SetOutput redirects warnings, such as an unreachable ssh-agent.
+func (k *Keyring) SetOutput(out io.Writer) {
+	k.out = out
+}
+
+// Close closes the connection to the ssh-agent, dialed again if needed.
+func (k *Keyring) Close() {
+	k.agentMu.Lock()
+	defer k.agentMu.Unlock()
+	if k.agentConn != nil {
+		_ = k.agentConn.Close()
+	}
+	k.agentConn, k.agent, k.agentErr, k.agentWarned = nil, nil, nil, false
+}
+
+// AuthMethods returns the authentication methods of a server. The keys of
+// agent and publickey are offered in one publickey method, as the server
+// is asked about each method once.
+func (k *Keyring) AuthMethods(auth SSHAuth) ([]ssh.AuthMethod, error) {
+	methods := auth.Methods
+	if len(methods) == 0 {
+		methods = []string{AuthAgent, AuthPublicKey}
+		if auth.Password != "" {
+			methods = append(methods, AuthPassword)
+		}
+		methods = append(methods, AuthKeyboardInteractive)
+	}
+	var result []ssh.AuthMethod
+	var sources []func() ([]ssh.Signer, error)
+	for _, method := range methods {
+		switch method {
+		case AuthAgent:
+			if os.Getenv("SSH_AUTH_SOCK") == "" {
+				if len(auth.Methods) > 0 {
+					return nil, fmt.Errorf("auth: agent requires SSH_AUTH_SOCK")
+				}
+				continue
+			}
+			if len(auth.Methods) > 0 {
+				sources = append(sources, k.agentSigners)
+			} else {
+				sources = append(sources, k.defaultAgentSigners)
+			}
+		case AuthPublicKey:
+			files := defaultIdentityFiles
+			if auth.IdentityFile != "" {
+				if _, err := os.Stat(expandHome(auth.IdentityFile)); err != nil {
+					return nil, fmt.Errorf("read identity file error: %s", err)
+				}
+				files = []string{auth.IdentityFile}
+			}
+			sources = append(sources, func() ([]ssh.Signer, error) {
+				return k.identitySigners(files, auth.CertificateFile)
+			})
+		case AuthPassword:
+			if auth.Password != "" {
+				result = append(result, ssh.Password(auth.Password))
+				continue
+			}
+			result = append(result, ssh.PasswordCallback(func() (string, error) {
+				return k.prompt(fmt.Sprintf("[%s] password: ", auth.Name), false)
+			}))
+		case AuthKeyboardInteractive:
+			result = append(result, ssh.KeyboardInteractive(k.challenge(auth)))
+		default:
+			return nil, fmt.Errorf("unknown auth method: %s", method)
+		}
+		if len(sources) == 1 && (method == AuthAgent || method == AuthPublicKey) {
+			result = append(result, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				var signers []ssh.Signer
+				for _, source := range sources {
+					items, err := source()
+					if err != nil {
+						return nil, err
+					}
+					signers = append(signers, items...)
+				}
+				return signers, nil
+			}))
+		}
+	}
+	return result, nil
+}
+
+// agentSigners returns the keys of the ssh-agent, connecting to it
+// on first use.
+func (k *Keyring) agentSigners() ([]ssh.Signer, error) {
+	k.agentMu.Lock()
+	if k.agent == nil && k.agentErr == nil {
+		conn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+		if err != nil {
+			k.agentErr = fmt.Errorf("connect ssh-agent error: %s", err)
+		} else {
+			k.agentConn, k.agent = conn, agent.NewClient(conn)
+		}
+	}
+	client, err := k.agent, k.agentErr
+	k.agentMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	signers, err := client.Signers()
+	if err != nil {
+		return nil, fmt.Errorf("list ssh-agent keys error: %s", err)
+	}
+	return signers, nil
+}
+
+// defaultAgentSigners is agentSigners for the default chain, which skips an
+// unreachable agent, e.g. a stale SSH_AUTH_SOCK, warning about it once.
+func (k *Keyring) defaultAgentSigners() ([]ssh.Signer, error) {
+	signers, err := k.agentSigners()
+	if err != nil {
+		k.agentMu.Lock()
+		if !k.agentWarned {
+			k.agentWarned = true
+			_, _ = fmt.Fprintf(k.out, "skip ssh-agent: %s\n", err)
+		}
+		k.agentMu.Unlock()
+		return nil, nil
+	}
+	return signers, nil
+}
+
+// identitySigners loads the keys of files that exist, with their certificate:
+// certFile for a single file, otherwise <file>-cert.pub if it exists.
+func (k *Keyring) identitySigners(files []string, certFile string) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	for _, file := range files {
+		file = expandHome(file)
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		signer, err := k.signer(file)
+		if err != nil {
+			return nil, err
+		}
+		cert := certFile
+		if cert == "" || len(files) > 1 {
+			cert = file + "-cert.pub"
+			if _, err = os.Stat(cert); err != nil {
+				signers = append(signers, signer)
+				continue
+			}
+		}
+		certSigner, err := certSigner(expandHome(cert), signer)
+		if err != nil {
+			return nil, err
+		}
+		// offer the certificate first, servers not trusting its CA
+		// may still accept the plain key
+		signers = append(signers, certSigner, signer)
+	}
+	return signers, nil
+}
+
+// signer parses a private key file. The passphrase of an encrypted key is
+// asked once, when the server accepts the key and it has to sign.
+func (k *Keyring) signer(file string) (ssh.Signer, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if signer, ok := k.signers[file]; ok {
+		return signer, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read identity file error: %s", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		pub := missing.PublicKey
+		if pub == nil {
+			pub, err = readPublicKey(file + ".pub")
+		}
+		if pub == nil {
+			return nil, fmt.Errorf("encrypted key %s needs %s.pub: %s", file, file, err)
+		}
+		signer, err = &encryptedSigner{pub: pub, file: file, data: data, prompt: k.prompt}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse identity file %s error: %s", file, err)
+	}
+	k.signers[file] = signer
+	return signer, nil
+}
+
+func readPublicKey(file string) (ssh.PublicKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	return pub, err
+}
+
+// encryptedSigner decrypts a passphrase protected key the first time it signs.
+type encryptedSigner struct {
+	pub    ssh.PublicKey
+	file   string
+	data   []byte
+	prompt Prompt
+	mu     sync.Mutex
+	signer ssh.AlgorithmSigner
+}
+
+func (s *encryptedSigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+func (s *encryptedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+func (s *encryptedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	signer, err := s.decrypt()
+	if err != nil {
+		return nil, err
+	}
+	return signer.SignWithAlgorithm(rand, data, algorithm)
+}
+
+func (s *encryptedSigner) decrypt() (ssh.AlgorithmSigner, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.signer != nil {
+		return s.signer, nil
+	}
+	passphrase, err := s.prompt(fmt.Sprintf("Enter passphrase for key '%s': ", s.file), false)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(s.data, []byte(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt identity file %s error: %s", s.file, err)
+	}
+	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("identity file %s: unsupported key type", s.file)
+	}
+	s.signer = algorithmSigner
+	return algorithmSigner, nil
+}
+
+func certSigner(file string, signer ssh.Signer) (ssh.Signer, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read certificate file error: %s", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate file %s error: %s", file, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a certificate", file)
+	}
+	return ssh.NewCertSigner(cert, signer)
+}
+
+// challenge answers keyboard-interactive questions, the password
+// question with the server password if it has one.
+func (k *Keyring) challenge(auth SSHAuth) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			if auth.Password != "" && strings.Contains(strings.ToLower(question), "password") {
+				answers[i] = auth.Password
+				continue
+			}
+			message := fmt.Sprintf("[%s] %s", auth.Name, question)
+			if instruction != "" && i == 0 {
+				message = fmt.Sprintf("[%s] %s\n%s", auth.Name, instruction, message)
+			}
+			answer, err := k.prompt(message, echos[i])
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+		}
+		return answers, nil
+	}
+}
+
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
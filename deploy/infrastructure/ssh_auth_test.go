@@ -0,0 +1,275 @@
+package infrastructure
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// writeEncryptedKey writes a passphrase protected key and its .pub file.
+func writeEncryptedKey(t *testing.T, file, passphrase string) ssh.PublicKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a legacy encrypted PEM key, which has no public key in it
+	block, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", der, []byte(passphrase), x509.PEMCipherAES128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(file+".pub", ssh.MarshalAuthorizedKey(pub), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+func TestKeyring_EncryptedKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "id_ecdsa")
+	pub := writeEncryptedKey(t, file, "secret")
+	prompts := 0
+	keyring := NewKeyring(func(message string, echo bool) (string, error) {
+		prompts++
+		return "secret", nil
+	})
+	signers, err := keyring.identitySigners([]string{file}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 1 || !bytes.Equal(signers[0].PublicKey().Marshal(), pub.Marshal()) {
+		t.Fatalf("expected the key of %s, got %v", file, signers)
+	}
+	if prompts != 0 {
+		t.Fatalf("passphrase asked before signing")
+	}
+	for i := 0; i < 2; i++ {
+		again, err := keyring.identitySigners([]string{file}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature, err := again[0].Sign(rand.Reader, []byte("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = pub.Verify([]byte("data"), signature); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if prompts != 1 {
+		t.Errorf("expected passphrase asked once, got %d", prompts)
+	}
+}
+
+func TestKeyring_WrongPassphrase(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "id_ecdsa")
+	writeEncryptedKey(t, file, "secret")
+	keyring := NewKeyring(func(message string, echo bool) (string, error) {
+		return "wrong", nil
+	})
+	signers, err := keyring.identitySigners([]string{file}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signers[0].Sign(rand.Reader, []byte("data")); err == nil {
+		t.Fatal("expected decrypt error")
+	}
+}
+
+func TestKeyring_Certificate(t *testing.T) {
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, _ := ssh.NewSignerFromKey(key)
+	ca, _ := ssh.NewSignerFromKey(caKey)
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "id_ecdsa")
+	if err = os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"deploy"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err = cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(file+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	signers, err := NewKeyring(nil).identitySigners([]string{file}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 2 {
+		t.Fatalf("expected certificate and key, got %d signers", len(signers))
+	}
+	if _, ok := signers[0].PublicKey().(*ssh.Certificate); !ok {
+		t.Errorf("expected the certificate offered first, got %s", signers[0].PublicKey().Type())
+	}
+	if !bytes.Equal(signers[1].PublicKey().Marshal(), signer.PublicKey().Marshal()) {
+		t.Errorf("expected the plain key offered second")
+	}
+}
+
+func TestKeyring_Challenge(t *testing.T) {
+	var asked []string
+	keyring := NewKeyring(func(message string, echo bool) (string, error) {
+		asked = append(asked, message)
+		return "123456", nil
+	})
+	challenge := keyring.challenge(SSHAuth{Name: "web", Password: "pw"})
+	answers, err := challenge("", "", []string{"Password: ", "Verification code: "}, []bool{false, false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answers[0] != "pw" || answers[1] != "123456" {
+		t.Errorf("unexpected answers %v", answers)
+	}
+	if len(asked) != 1 || asked[0] != "[web] Verification code: " {
+		t.Errorf("expected only the code asked, got %q", asked)
+	}
+}
+
+func TestKeyring_AuthMethods(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	keyring := NewKeyring(nil)
+	methods, err := keyring.AuthMethods(SSHAuth{Password: "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// publickey, password and keyboard-interactive, agent skipped without a socket
+	if len(methods) != 3 {
+		t.Errorf("expected 3 methods, got %d", len(methods))
+	}
+	if _, err = keyring.AuthMethods(SSHAuth{Methods: []string{AuthAgent}}); err == nil {
+		t.Error("expected agent error without SSH_AUTH_SOCK")
+	}
+	if _, err = keyring.AuthMethods(SSHAuth{Methods: []string{"otp"}}); err == nil {
+		t.Error("expected unknown method error")
+	}
+}
+
+func TestKeyring_StaleAgent(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(dir, "agent.sock"))
+	server := serveSSH(t)
+	keyring := NewKeyring(nil)
+	var out bytes.Buffer
+	keyring.SetOutput(&out)
+
+	// the default chain goes on with the password
+	methods, err := keyring.AuthMethods(SSHAuth{Name: "web", Password: "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		opts := testSSHOptions(server.address, "pw")
+		opts.Auth = methods
+		conn, err := DialSSH(opts)
+		if err != nil {
+			t.Fatalf("expected the stale agent skipped, got %s", err)
+		}
+		conn.Close()
+	}
+	if n := strings.Count(out.String(), "skip ssh-agent"); n != 1 {
+		t.Errorf("expected one warning, got %q", out.String())
+	}
+
+	// an agent asked for explicitly is required
+	methods, err = keyring.AuthMethods(SSHAuth{Name: "web", Methods: []string{AuthAgent, AuthPassword}, Password: "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := testSSHOptions(server.address, "pw")
+	opts.Auth = methods
+	if _, err = DialSSH(opts); err == nil || !strings.Contains(err.Error(), "ssh-agent") {
+		t.Errorf("expected ssh-agent error, got %v", err)
+	}
+}
+
+func TestKeyring_AgentConn(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	t.Setenv("SSH_AUTH_SOCK", socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := agent.NewKeyring()
+	if err = keys.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan struct{}, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = agent.ServeAgent(keys, conn)
+				served <- struct{}{}
+			}()
+		}
+	}()
+
+	keyring := NewKeyring(nil)
+	for i := 0; i < 2; i++ {
+		signers, err := keyring.agentSigners()
+		if err != nil || len(signers) != 1 {
+			t.Fatalf("expected the agent key, got %d %v", len(signers), err)
+		}
+	}
+	keyring.Close()
+	select {
+	case <-served:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the agent connection closed")
+	}
+	select {
+	case <-served:
+		t.Error("expected a single agent connection")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
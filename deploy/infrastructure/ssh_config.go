@@ -0,0 +1,295 @@
+package infrastructure
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SSHHost is a host resolved from OpenSSH config files.
+type SSHHost struct {
+	Alias    string
+	HostName string
+	// Port and User are empty if no block sets them.
+	Port          int
+	User          string
+	IdentityFiles []string
+	// ProxyJump and ProxyCommand are empty if no block sets them or they are none.
+	ProxyJump    string
+	ProxyCommand string
+}
+
+// SSHConfig holds the Host blocks of OpenSSH config files, e.g. ~/.ssh/config.
+// Only Host and `Match all` blocks are supported, other Match blocks never match.
+type SSHConfig struct {
+	blocks []*sshConfigBlock
+}
+
+type sshConfigBlock struct {
+	patterns []string
+	options  [][2]string
+}
+
+// matches reports whether a block applies to alias: a pattern matches
+// it and no negated pattern does.
+func (b *sshConfigBlock) matches(alias string) bool {
+	matched := false
+	for _, pattern := range b.patterns {
+		if negated := strings.HasPrefix(pattern, "!"); negated {
+			if matchHostPattern(pattern[1:], alias) {
+				return false
+			}
+		} else if matchHostPattern(pattern, alias) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// LoadSSHConfig parses OpenSSH config files in order of precedence,
+// following their Include directives. Missing files are skipped.
+func LoadSSHConfig(files ...string) (*SSHConfig, error) {
+	config := &SSHConfig{}
+	for _, file := range files {
+		// options before any Host apply to every host
+		config.blocks = append(config.blocks, &sshConfigBlock{patterns: []string{"*"}})
+		if err := config.parse(expandHome(file), 0); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+func (c *SSHConfig) parse(file string, depth int) error {
+	if depth > 16 {
+		return fmt.Errorf("ssh config %s: too many nested includes", file)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read ssh config error: %s", err)
+	}
+	defer func() { _ = f.Close() }()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		key, value := splitSSHConfigLine(scanner.Text())
+		switch key {
+		case "":
+		case "host":
+			c.blocks = append(c.blocks, &sshConfigBlock{patterns: splitSSHConfigArgs(strings.ToLower(value))})
+		case "match":
+			block := &sshConfigBlock{}
+			if strings.EqualFold(strings.TrimSpace(value), "all") {
+				block.patterns = []string{"*"}
+			}
+			c.blocks = append(c.blocks, block)
+		case "include":
+			for _, pattern := range splitSSHConfigArgs(value) {
+				pattern = expandHome(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(filepath.Dir(file), pattern)
+				}
+				matches, err := filepath.Glob(pattern)
+				if err != nil {
+					return fmt.Errorf("%s:%d: invalid include %s: %s", file, line, pattern, err)
+				}
+				for _, match := range matches {
+					if err = c.parse(match, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			block := c.blocks[len(c.blocks)-1]
+			block.options = append(block.options, [2]string{key, value})
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("read ssh config error: %s", err)
+	}
+	return nil
+}
+
+// splitSSHConfigLine returns the lower cased keyword and the value
+// of a `Keyword value` or `Keyword=value` line.
+func splitSSHConfigLine(line string) (key, value string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", ""
+	}
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return strings.ToLower(line), ""
+	}
+	key, value = strings.ToLower(line[:i]), strings.TrimSpace(line[i:])
+	value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+	return key, value
+}
+
+// splitSSHConfigArgs splits a value into arguments, honoring double quotes.
+func splitSSHConfigArgs(value string) []string {
+	var args []string
+	var b strings.Builder
+	quoted, started := false, false
+	for _, c := range value {
+		switch {
+		case c == '"':
+			quoted, started = !quoted, true
+		case (c == ' ' || c == '\t') && !quoted:
+			if started {
+				args = append(args, b.String())
+				b.Reset()
+				started = false
+			}
+		default:
+			b.WriteRune(c)
+			started = true
+		}
+	}
+	if started {
+		args = append(args, b.String())
+	}
+	return args
+}
+
+// Resolve returns the settings of host alias. As OpenSSH does, the first
+// value found for a setting wins, IdentityFile values add up.
+func (c *SSHConfig) Resolve(alias string) (*SSHHost, error) {
+	host := &SSHHost{Alias: alias}
+	seen := map[string]bool{}
+	for _, block := range c.blocks {
+		if !block.matches(strings.ToLower(alias)) {
+			continue
+		}
+		for _, option := range block.options {
+			key, value := option[0], unquote(option[1])
+			if key == "identityfile" {
+				host.IdentityFiles = append(host.IdentityFiles, value)
+				continue
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			switch key {
+			case "hostname":
+				host.HostName = value
+			case "port":
+				port, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("ssh config %s: invalid port: %s", alias, value)
+				}
+				host.Port = port
+			case "user":
+				host.User = value
+			case "proxyjump":
+				if value != "none" {
+					host.ProxyJump = value
+				}
+			case "proxycommand":
+				if value != "none" {
+					host.ProxyCommand = value
+				}
+			}
+		}
+	}
+	tokens := map[byte]string{'n': alias, 'h': alias, 'r': host.User, '%': "%"}
+	if host.HostName != "" {
+		host.HostName = expandTokens(host.HostName, tokens)
+		tokens['h'] = host.HostName
+	} else {
+		host.HostName = alias
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		tokens['d'] = home
+	}
+	if u, err := user.Current(); err == nil {
+		tokens['u'] = u.Username
+		if host.User == "" {
+			tokens['r'] = u.Username
+		}
+	}
+	tokens['p'] = "22"
+	if host.Port != 0 {
+		tokens['p'] = strconv.Itoa(host.Port)
+	}
+	for i, file := range host.IdentityFiles {
+		host.IdentityFiles[i] = expandHome(expandTokens(file, tokens))
+	}
+	host.ProxyCommand = expandTokens(host.ProxyCommand, tokens)
+	return host, nil
+}
+
+// Hosts returns the aliases of the Host blocks without wildcards, in order.
+func (c *SSHConfig) Hosts() []string {
+	var hosts []string
+	seen := map[string]bool{}
+	for _, block := range c.blocks {
+		for _, pattern := range block.patterns {
+			if strings.ContainsAny(pattern, "*?!") || seen[pattern] {
+				continue
+			}
+			seen[pattern] = true
+			hosts = append(hosts, pattern)
+		}
+	}
+	return hosts
+}
+
+// matchHostPattern matches s against an OpenSSH pattern, where * matches
+// any characters and ? matches one.
+func matchHostPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchHostPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// expandTokens replaces %x tokens of OpenSSH config values, leaving unknown ones.
+func expandTokens(s string, tokens map[byte]string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+1 < len(s) {
+			if value, ok := tokens[s[i+1]]; ok {
+				b.WriteString(value)
+				i++
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
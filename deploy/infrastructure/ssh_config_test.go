@@ -0,0 +1,123 @@
+package infrastructure
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeSSHConfig(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestSSHConfig_Resolve(t *testing.T) {
+	dir := t.TempDir()
+	writeSSHConfig(t, dir, "conf.d/bastion", `
+Host bastion
+  HostName 10.0.0.9
+  Port 2200
+`)
+	file := writeSSHConfig(t, dir, "config", `
+User nobody
+Include conf.d/*
+
+Host web db
+  HostName %h.example.com
+  User deploy
+  IdentityFile /keys/%r_%n
+
+Host *.internal !legacy.internal
+  ProxyJump bastion
+  User ops
+
+Host legacy.internal
+  ProxyCommand nc -X connect -x proxy:3128 %h %p
+
+Host *
+  User root
+  IdentityFile /keys/default
+  ProxyJump none
+`)
+	config, err := LoadSSHConfig(file, filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		alias string
+		want  SSHHost
+	}{
+		{"bastion", SSHHost{HostName: "10.0.0.9", Port: 2200, User: "nobody",
+			IdentityFiles: []string{"/keys/default"}}},
+		{"web", SSHHost{HostName: "web.example.com", User: "nobody",
+			IdentityFiles: []string{"/keys/nobody_web", "/keys/default"}}},
+		{"app.internal", SSHHost{HostName: "app.internal", User: "nobody", ProxyJump: "bastion",
+			IdentityFiles: []string{"/keys/default"}}},
+		{"legacy.internal", SSHHost{HostName: "legacy.internal", User: "nobody",
+			ProxyCommand: "nc -X connect -x proxy:3128 legacy.internal 22", IdentityFiles: []string{"/keys/default"}}},
+	}
+	for _, test := range tests {
+		host, err := config.Resolve(test.alias)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.want.Alias = test.alias
+		if !reflect.DeepEqual(*host, test.want) {
+			t.Errorf("%s: expected %+v, got %+v", test.alias, test.want, *host)
+		}
+	}
+	if hosts := config.Hosts(); !reflect.DeepEqual(hosts, []string{"bastion", "web", "db", "legacy.internal"}) {
+		t.Errorf("unexpected hosts %v", hosts)
+	}
+}
+
+func TestSSHConfig_FirstValueWins(t *testing.T) {
+	file := writeSSHConfig(t, t.TempDir(), "config", `
+Host=web
+  Port=2222
+Match all
+  Port 22
+  User "deploy user"
+Match host web
+  User skipped
+`)
+	config, err := LoadSSHConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, err := config.Resolve("WEB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host.Port != 2222 || host.User != "deploy user" {
+		t.Errorf("expected port 2222 and user 'deploy user', got %d and %q", host.Port, host.User)
+	}
+}
+
+func TestMatchHostPattern(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "anything", true},
+		{"web?", "web1", true},
+		{"web?", "web12", false},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"10.0.*.1", "10.0.3.1", true},
+		{"web[1]", "web1", false},
+	}
+	for _, test := range tests {
+		if got := matchHostPattern(test.pattern, test.s); got != test.want {
+			t.Errorf("match %q %q: expected %v", test.pattern, test.s, test.want)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHOptions configures DialSSH.
+type SSHOptions struct {
+	Host string
+	Port int
+	User string
+	// Auth lists the authentication methods, see Keyring.
+	Auth []ssh.AuthMethod
+	// HostKeyCallback verifies the server host key, see KnownHosts.
+	HostKeyCallback ssh.HostKeyCallback
+	// HostKeyAlgorithms restricts the host keys the server may present,
+	// see KnownHosts.HostKeyAlgorithms. All are accepted when empty.
+	HostKeyAlgorithms []string
+	// Timeout bounds each step of connecting: the TCP connection, to the
+	// server or through a proxy or jump server, then the SSH handshake
+	// with authentication.
+	Timeout time.Duration
+	// Retries is how many times connecting is retried when the server
+	// can't be reached, waiting RetryWait first, then twice as long
+	// on each retry. Authentication failures aren't retried.
+	Retries   int
+	RetryWait time.Duration
+	// OnRetry, if set, is told about each retry before waiting.
+	OnRetry func(err error, wait time.Duration)
+	// KeepAlive is the interval of keepalive requests, none unless positive. The
+	// connection is closed when KeepAliveMax of them go unanswered.
+	KeepAlive    time.Duration
+	KeepAliveMax int
+	// Jump is the server to connect through, as with ssh -J.
+	Jump *SSHOptions
+	// ProxyCommand is a local command whose stdin and stdout are
+	// the connection to the server, as in OpenSSH config.
+	ProxyCommand string
+	// Proxy is the URL of a SOCKS5 or HTTP proxy to connect through.
+	Proxy string
+}
+
+func (o SSHOptions) address() string {
+	return net.JoinHostPort(o.Host, strconv.Itoa(o.Port))
+}
+
+// SSHConn is an SSH connection to a server with its SFTP client, shared by
+// SFTP and exec sessions. A dropped connection is dialed again by Ensure.
+type SSHConn struct {
+	opts   SSHOptions
+	mu     sync.Mutex
+	client *ssh.Client
+	sftp   *sftp.Client
+	// jumps are the connections to the jump servers, closed with the client.
+	jumps []*ssh.Client
+	// dropped is closed when the client connection ends.
+	dropped chan struct{}
+	closed  bool
+}
+
+// DialSSH connects and authenticates to a server, through its jump servers,
+// proxy command or proxy if any, then starts SFTP on the connection.
+func DialSSH(opts SSHOptions) (*SSHConn, error) {
+	conn := &SSHConn{opts: opts}
+	if err := conn.dial(); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dial connects with the retry policy of the options, then starts
+// SFTP and the keepalives on the connection.
+func (c *SSHConn) dial() error {
+	wait := c.opts.RetryWait
+	var client *ssh.Client
+	var jumps []*ssh.Client
+	var err error
+	for retry := 0; ; retry++ {
+		client, jumps, err = dialClient(c.opts)
+		if err == nil {
+			break
+		}
+		var unreachable *unreachableError
+		if retry >= c.opts.Retries || !errors.As(err, &unreachable) {
+			return err
+		}
+		if c.opts.OnRetry != nil {
+			c.opts.OnRetry(err, wait)
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		_ = client.Close()
+		closeClients(jumps)
+		return fmt.Errorf("init sftp client error: %s", err)
+	}
+	dropped := make(chan struct{})
+	go func() {
+		_ = client.Wait()
+		close(dropped)
+	}()
+	if c.opts.KeepAlive > 0 {
+		go keepAlive(client, c.opts.KeepAlive, c.opts.KeepAliveMax, dropped)
+	}
+	c.client, c.sftp, c.jumps, c.dropped = client, sftpClient, jumps, dropped
+	return nil
+}
+
+// keepAlive sends keepalive requests every interval until the connection
+// drops, closing it once max requests in a row go unanswered.
+func keepAlive(client *ssh.Client, interval time.Duration, max int, dropped <-chan struct{}) {
+	if max <= 0 {
+		max = 1
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	missed := 0
+	for {
+		select {
+		case <-dropped:
+			return
+		case <-ticker.C:
+		}
+		reply := make(chan error, 1)
+		go func() {
+			// servers answer unknown requests with a failure, which is fine
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+		select {
+		case <-dropped:
+			return
+		case err := <-reply:
+			if err != nil {
+				_ = client.Close()
+				return
+			}
+			missed = 0
+		case <-time.After(interval):
+			if missed++; missed >= max {
+				_ = client.Close()
+				return
+			}
+		}
+	}
+}
+
+// Ensure dials the server again if the connection dropped, reporting
+// whether it did.
+func (c *SSHConn) Ensure() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false, fmt.Errorf("connection closed")
+	}
+	select {
+	case <-c.dropped:
+	default:
+		return false, nil
+	}
+	_ = c.sftp.Close()
+	closeClients(c.jumps)
+	if err := c.dial(); err != nil {
+		return false, fmt.Errorf("reconnect server error: %s", err)
+	}
+	return true, nil
+}
+
+// unreachableError is a failure to reach the server, worth a retry,
+// as opposed to a failed handshake or authentication.
+type unreachableError struct {
+	err error
+}
+
+func (e *unreachableError) Error() string {
+	return e.err.Error()
+}
+
+// dialClient returns the client of a server and of the jump servers
+// it was reached through, the nearest first.
+func dialClient(opts SSHOptions) (*ssh.Client, []*ssh.Client, error) {
+	config := &ssh.ClientConfig{
+		User:              opts.User,
+		Auth:              opts.Auth,
+		HostKeyCallback:   opts.HostKeyCallback,
+		HostKeyAlgorithms: opts.HostKeyAlgorithms,
+	}
+	var conn net.Conn
+	var jumps []*ssh.Client
+	var err error
+	switch {
+	case opts.Jump != nil:
+		jump, through, jumpErr := dialClient(*opts.Jump)
+		if jumpErr != nil {
+			err = fmt.Errorf("jump server %s: %s", opts.Jump.address(), jumpErr)
+			var unreachable *unreachableError
+			if errors.As(jumpErr, &unreachable) {
+				err = &unreachableError{err}
+			}
+			return nil, nil, err
+		}
+		jumps = append([]*ssh.Client{jump}, through...)
+		conn, err = dialThrough(jump, opts.address(), opts.Timeout)
+		if err != nil {
+			closeClients(jumps)
+			return nil, nil, &unreachableError{fmt.Errorf("connect server through %s error: %s", opts.Jump.address(), err)}
+		}
+	case opts.ProxyCommand != "":
+		conn, err = dialCommand(opts.ProxyCommand, opts.address())
+		if err != nil {
+			return nil, nil, err
+		}
+	case opts.Proxy != "":
+		conn, err = dialProxy(opts.Proxy, opts.address(), opts.Timeout)
+		if err != nil {
+			return nil, nil, &unreachableError{err}
+		}
+	default:
+		conn, err = net.DialTimeout("tcp", opts.address(), opts.Timeout)
+		if err != nil {
+			return nil, nil, &unreachableError{fmt.Errorf("connect server error: %s", err)}
+		}
+	}
+	c, chans, reqs, err := handshake(conn, opts.address(), config, opts.Timeout)
+	if err != nil {
+		_ = conn.Close()
+		closeClients(jumps)
+		// a server too busy to answer is retried
+		var unreachable *unreachableError
+		if errors.As(err, &unreachable) {
+			return nil, nil, &unreachableError{fmt.Errorf("connect server error: %s", err)}
+		}
+		return nil, nil, fmt.Errorf("connect server error: %s", err)
+	}
+	return ssh.NewClient(c, chans, reqs), jumps, nil
+}
+
+// dialThrough opens a connection to address through a jump server,
+// giving up after timeout if the jump server doesn't answer.
+func dialThrough(jump *ssh.Client, address string, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		return jump.Dial("tcp", address)
+	}
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := jump.Dial("tcp", address)
+		done <- result{conn, err}
+	}()
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-time.After(timeout):
+		// close the connection if it is opened after all
+		go func() {
+			if r := <-done; r.conn != nil {
+				_ = r.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("no answer after %s", timeout)
+	}
+}
+
+// handshake runs the SSH handshake and authentication on conn, closing it
+// if they don't complete within timeout. Deadlines would not do, the
+// channels of jump servers and proxy commands don't support them.
+func handshake(conn net.Conn, address string, config *ssh.ClientConfig, timeout time.Duration) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+	if timeout <= 0 {
+		return ssh.NewClientConn(conn, address, config)
+	}
+	timer := time.AfterFunc(timeout, func() {
+		_ = conn.Close()
+	})
+	c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if !timer.Stop() {
+		if err == nil {
+			_ = c.Close()
+		}
+		return nil, nil, nil, &unreachableError{fmt.Errorf("ssh handshake not completed after %s", timeout)}
+	}
+	return c, chans, reqs, err
+}
+
+func closeClients(clients []*ssh.Client) {
+	for _, client := range clients {
+		_ = client.Close()
+	}
+}
+
+// SSHClient returns the client of the current connection.
+func (c *SSHConn) SSHClient() *ssh.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client
+}
+
+// SFTPClient returns the SFTP client of the current connection.
+func (c *SSHConn) SFTPClient() *sftp.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sftp
+}
+
+func (c *SSHConn) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	_ = c.sftp.Close()
+	_ = c.client.Close()
+	closeClients(c.jumps)
+}
+
+// Ping checks the server runs commands.
+func (c *SSHConn) Ping() error {
+	session, err := c.SSHClient().NewSession()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = session.Close() }()
+	out, err := session.CombinedOutput(`echo "pong"`)
+	if err != nil {
+		return fmt.Errorf("ping error: %s", err)
+	}
+	if strings.TrimSpace(string(out)) != "pong" {
+		return fmt.Errorf("ping error: server not response 'pong'")
+	}
+	return nil
+}
+
+// CombinedExec runs a command, returning its output as the error if it fails.
+func (c *SSHConn) CombinedExec(command string) error {
+	session, err := c.SSHClient().NewSession()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = session.Close() }()
+	out, err := session.CombinedOutput(command)
+	if err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// PipeExec runs a command with its output piped to the console.
+func (c *SSHConn) PipeExec(command string) error {
+	return c.Run(command, nil, os.Stdout, os.Stderr)
+}
+
+// Run runs a command in a new session with the given stdin and outputs.
+func (c *SSHConn) Run(command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	session, err := c.SSHClient().NewSession()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = session.Close() }()
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if err = session.Run(command); err != nil {
+		return fmt.Errorf("session run command error: %s", err)
+	}
+	return nil
+}
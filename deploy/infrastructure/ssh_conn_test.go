@@ -0,0 +1,405 @@
+package infrastructure
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshServer accepts the password "pw", no key, and serves SFTP, counting
+// handshakes.
+type sshServer struct {
+	address string
+	mu      sync.Mutex
+	conns   []net.Conn
+	// hold leaves direct-tcpip channels unanswered, as a stuck jump
+	// server does.
+	hold bool
+}
+
+func (s *sshServer) holding() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hold
+}
+
+func (s *sshServer) handshakes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// drop closes the connections from the server side.
+func (s *sshServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+// serveSSH starts a server with hostKeys, an ed25519 key if none.
+func serveSSH(t *testing.T, hostKeys ...ssh.Signer) *sshServer {
+	t.Helper()
+	if len(hostKeys) == 0 {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hostKey, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hostKeys = append(hostKeys, hostKey)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "pw" {
+				return nil, fmt.Errorf("wrong password")
+			}
+			return nil, nil
+		},
+		// offered, so that clients try their keys first
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, fmt.Errorf("unknown key")
+		},
+	}
+	for _, hostKey := range hostKeys {
+		config.AddHostKey(hostKey)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &sshServer{address: listener.Addr().String()}
+	t.Cleanup(func() {
+		_ = listener.Close()
+		server.drop()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn, config)
+		}
+	}()
+	return server
+}
+
+func (s *sshServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	s.mu.Lock()
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			if !s.holding() {
+				go forward(newChannel)
+			}
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(channel)
+					if err == nil {
+						_ = server.Serve()
+					}
+					_ = channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+// forward serves a direct-tcpip channel, as jump servers do.
+func forward(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, fmt.Sprint(target.Port)))
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		_, _ = io.Copy(conn, channel)
+		_ = conn.Close()
+	}()
+	_, _ = io.Copy(channel, conn)
+	_ = channel.Close()
+}
+
+func testSSHOptions(address, password string) SSHOptions {
+	host, port, _ := net.SplitHostPort(address)
+	var portNumber int
+	_, _ = fmt.Sscan(port, &portNumber)
+	return SSHOptions{
+		Host:            host,
+		Port:            portNumber,
+		User:            "deploy",
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+		RetryWait:       time.Millisecond,
+	}
+}
+
+func TestDialSSH_RetriesUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	_ = listener.Close()
+	opts := testSSHOptions(address, "pw")
+	opts.Retries = 2
+	var waits []time.Duration
+	opts.OnRetry = func(err error, wait time.Duration) {
+		waits = append(waits, wait)
+	}
+	if _, err = DialSSH(opts); err == nil {
+		t.Fatal("expected connect error")
+	}
+	if len(waits) != 2 || waits[0] != time.Millisecond || waits[1] != 2*time.Millisecond {
+		t.Errorf("expected 2 retries waiting 1ms then 2ms, got %v", waits)
+	}
+}
+
+func TestDialSSH_NoRetryOnAuth(t *testing.T) {
+	server := serveSSH(t)
+	opts := testSSHOptions(server.address, "wrong")
+	opts.Retries = 2
+	retries := 0
+	opts.OnRetry = func(err error, wait time.Duration) {
+		retries++
+	}
+	if _, err := DialSSH(opts); err == nil {
+		t.Fatal("expected auth error")
+	}
+	if retries != 0 {
+		t.Errorf("expected no retry of a failed authentication, got %d", retries)
+	}
+}
+
+func TestSSHConn_Ensure(t *testing.T) {
+	server := serveSSH(t)
+	conn, err := DialSSH(testSSHOptions(server.address, "pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 3; i++ {
+		reconnected, err := conn.Ensure()
+		if err != nil || reconnected {
+			t.Fatalf("expected the connection reused, got %v %v", reconnected, err)
+		}
+		if _, err = conn.SFTPClient().Getwd(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := server.handshakes(); n != 1 {
+		t.Fatalf("expected 1 handshake, got %d", n)
+	}
+
+	server.drop()
+	// the drop is noticed once the client reads the closed connection
+	deadline := time.Now().Add(2 * time.Second)
+	reconnected := false
+	for !reconnected && time.Now().Before(deadline) {
+		if reconnected, err = conn.Ensure(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !reconnected {
+		t.Fatal("expected a reconnect")
+	}
+	if _, err = conn.SFTPClient().Getwd(); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.handshakes(); n != 2 {
+		t.Errorf("expected 2 handshakes, got %d", n)
+	}
+}
+
+func TestSSHConn_KeepAlive(t *testing.T) {
+	// a server that stops answering: once connected, the replies
+	// of the server are dropped
+	server := serveSSH(t)
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = proxy.Close() }()
+	stalled := make(chan struct{})
+	go func() {
+		for {
+			client, err := proxy.Accept()
+			if err != nil {
+				return
+			}
+			target, err := net.Dial("tcp", server.address)
+			if err != nil {
+				return
+			}
+			go func() {
+				buf := make([]byte, 32*1024)
+				for {
+					select {
+					case <-stalled:
+						return
+					default:
+					}
+					n, err := client.Read(buf)
+					if err != nil {
+						return
+					}
+					_, _ = target.Write(buf[:n])
+				}
+			}()
+			go func() {
+				buf := make([]byte, 32*1024)
+				for {
+					n, err := target.Read(buf)
+					if err != nil {
+						return
+					}
+					select {
+					case <-stalled:
+						continue
+					default:
+					}
+					_, _ = client.Write(buf[:n])
+				}
+			}()
+		}
+	}()
+	opts := testSSHOptions(proxy.Addr().String(), "pw")
+	opts.KeepAlive = 20 * time.Millisecond
+	opts.KeepAliveMax = 2
+	conn, err := DialSSH(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	close(stalled)
+
+	done := make(chan struct{})
+	go func() {
+		_ = conn.SSHClient().Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the unanswered connection closed by keepalives")
+	}
+}
+
+// silentListener accepts connections and never speaks SSH.
+func silentListener(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		_ = listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestDialSSH_HandshakeTimeout(t *testing.T) {
+	opts := testSSHOptions(silentListener(t), "pw")
+	opts.Timeout = 100 * time.Millisecond
+	opts.Retries = 1
+	retries := 0
+	opts.OnRetry = func(err error, wait time.Duration) {
+		retries++
+	}
+	start := time.Now()
+	_, err := DialSSH(opts)
+	if err == nil || !strings.Contains(err.Error(), "handshake not completed after 100ms") {
+		t.Fatalf("expected handshake timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected to give up after the timeout, took %s", elapsed)
+	}
+	if retries != 1 {
+		t.Errorf("expected the timeout retried, got %d retries", retries)
+	}
+}
+
+func TestDialClient_JumpTimeout(t *testing.T) {
+	jump, target := serveSSH(t), serveSSH(t)
+	jump.mu.Lock()
+	jump.hold = true
+	jump.mu.Unlock()
+	jumpOpts := testSSHOptions(jump.address, "pw")
+	opts := testSSHOptions(target.address, "pw")
+	opts.Jump = &jumpOpts
+	opts.Timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, _, err := dialClient(opts)
+	if err == nil || !strings.Contains(err.Error(), "no answer after 100ms") {
+		t.Fatalf("expected jump dial timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected to give up after the timeout, took %s", elapsed)
+	}
+}
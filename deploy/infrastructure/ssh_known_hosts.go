@@ -0,0 +1,227 @@
+package infrastructure
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key policies, see KnownHosts.Callback.
+const (
+	// HostKeyStrict accepts only keys found in a known_hosts file.
+	HostKeyStrict = "strict"
+	// HostKeyTOFU trusts the key of a host seen for the first time,
+	// adding it to the store, and rejects keys that changed.
+	HostKeyTOFU = "tofu"
+	// HostKeyOff accepts any key.
+	HostKeyOff = "off"
+)
+
+// KnownHosts verifies server host keys against OpenSSH known_hosts files
+// and a store managed by cast, into which new keys are trusted.
+type KnownHosts struct {
+	files []string
+	store string
+	mu    sync.Mutex
+}
+
+// NewKnownHosts creates a KnownHosts reading files, e.g. ~/.ssh/known_hosts,
+// and store, which is also written to. Missing files are skipped.
+func NewKnownHosts(files []string, store string) *KnownHosts {
+	return &KnownHosts{files: files, store: store}
+}
+
+// Callback returns the host key check of a policy, tofu by default. A
+// fingerprint such as SHA256:... pins the key instead, whatever the policy.
+// Keys trusted on first use are reported to out.
+func (k *KnownHosts) Callback(policy, fingerprint string, out io.Writer) (ssh.HostKeyCallback, error) {
+	if fingerprint != "" {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if got := ssh.FingerprintSHA256(key); got != fingerprint && strings.TrimPrefix(got, "SHA256:") != fingerprint {
+				return fmt.Errorf("host key of %s is %s, expect %s", hostname, got, fingerprint)
+			}
+			return nil
+		}, nil
+	}
+	switch policy {
+	case HostKeyOff:
+		return ssh.InsecureIgnoreHostKey(), nil
+	case HostKeyStrict, HostKeyTOFU, "":
+	default:
+		return nil, fmt.Errorf("unknown host key policy: %s", policy)
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		known, err := k.check(hostname, remote, key)
+		if err != nil || known {
+			return err
+		}
+		if policy == HostKeyStrict {
+			return fmt.Errorf("host key of %s is unknown (%s), trust it with cast server trust or set host_key",
+				hostname, ssh.FingerprintSHA256(key))
+		}
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		// another connection may have trusted it meanwhile
+		if known, err = k.check(hostname, remote, key); err != nil || known {
+			return err
+		}
+		if err = k.appendLine(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(out, "trusted host key of %s on first use: %s\n", hostname, ssh.FingerprintSHA256(key))
+		return nil
+	}, nil
+}
+
+// HostKeyAlgorithms returns the algorithms of the keys known for hostname,
+// nil if none is. Offering only these, as OpenSSH does, a server with keys
+// of several types presents the known one rather than a key of another
+// type, which would be rejected as changed.
+func (k *KnownHosts) HostKeyAlgorithms(hostname string) ([]string, error) {
+	callback, err := k.callback()
+	if err != nil || callback == nil {
+		return nil, err
+	}
+	// a key known for no host, to learn the keys known for hostname
+	probe, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	if err != nil {
+		return nil, err
+	}
+	var keyErr *knownhosts.KeyError
+	if err = callback(hostname, &net.TCPAddr{}, probe); !errors.As(err, &keyErr) {
+		return nil, err
+	}
+	var algorithms []string
+	for _, want := range keyErr.Want {
+		if want.Key.Type() == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algorithms = append(algorithms, want.Key.Type())
+	}
+	return algorithms, nil
+}
+
+// callback reads the known_hosts files, nil if there are none.
+func (k *KnownHosts) callback() (ssh.HostKeyCallback, error) {
+	var files []string
+	for _, file := range append(append([]string{}, k.files...), k.store) {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, fmt.Errorf("read known_hosts error: %s", err)
+	}
+	return callback, nil
+}
+
+// check reports whether key is known for hostname, an error
+// if another key is known for it.
+func (k *KnownHosts) check(hostname string, remote net.Addr, key ssh.PublicKey) (bool, error) {
+	callback, err := k.callback()
+	if err != nil || callback == nil {
+		return false, err
+	}
+	err = callback(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &keyErr) && len(keyErr.Want) == 0:
+		return false, nil
+	case errors.As(err, &keyErr):
+		return false, fmt.Errorf("host key of %s changed to %s, it doesn't match %s:%d; "+
+			"if the server was reinstalled, trust the new key with cast server trust",
+			hostname, ssh.FingerprintSHA256(key), keyErr.Want[0].Filename, keyErr.Want[0].Line)
+	}
+	return false, err
+}
+
+// Trust records key as the host key of address in the store,
+// replacing the keys recorded there for it.
+func (k *KnownHosts) Trust(address string, key ssh.PublicKey) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	host := knownhosts.Normalize(address)
+	data, err := os.ReadFile(k.store)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read known_hosts error: %s", err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || hasHost(line, host) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, knownhosts.Line([]string{host}, key))
+	if err = os.MkdirAll(filepath.Dir(k.store), 0700); err != nil {
+		return fmt.Errorf("create known_hosts dir error: %s", err)
+	}
+	return os.WriteFile(k.store, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+func (k *KnownHosts) appendLine(line string) error {
+	if err := os.MkdirAll(filepath.Dir(k.store), 0700); err != nil {
+		return fmt.Errorf("create known_hosts dir error: %s", err)
+	}
+	file, err := os.OpenFile(k.store, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("open known_hosts error: %s", err)
+	}
+	defer func() { _ = file.Close() }()
+	_, err = fmt.Fprintln(file, line)
+	return err
+}
+
+// hasHost reports whether a known_hosts line lists host.
+func hasHost(line, host string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	for _, h := range strings.Split(fields[0], ",") {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// errHostKeyFetched aborts the handshake once FetchHostKey has the key.
+var errHostKeyFetched = errors.New("host key fetched")
+
+// FetchHostKey returns the host key a server presents, without authenticating
+// to it. Its jump servers, if any, are authenticated to as usual.
+func FetchHostKey(opts SSHOptions) (ssh.PublicKey, error) {
+	var fetched ssh.PublicKey
+	opts.Auth = nil
+	// whatever key it has, even of a type not known yet
+	opts.HostKeyAlgorithms = nil
+	opts.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fetched = key
+		return errHostKeyFetched
+	}
+	client, jumps, err := dialClient(opts)
+	if err == nil {
+		_ = client.Close()
+		closeClients(jumps)
+	}
+	if fetched == nil {
+		return nil, fmt.Errorf("fetch host key of %s error: %s", opts.address(), err)
+	}
+	return fetched, nil
+}
@@ -0,0 +1,176 @@
+package infrastructure
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+var testRemote = &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+func TestKnownHosts_TOFU(t *testing.T) {
+	dir := t.TempDir()
+	hosts := NewKnownHosts([]string{filepath.Join(dir, "missing")}, filepath.Join(dir, "known_hosts"))
+	callback, err := hosts.Callback(HostKeyTOFU, "", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := newHostKey(t)
+	if err = callback("10.0.0.1:22", testRemote, key); err != nil {
+		t.Fatalf("first use should be trusted: %s", err)
+	}
+	if err = callback("10.0.0.1:22", testRemote, key); err != nil {
+		t.Fatalf("trusted key should be accepted: %s", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "known_hosts"))
+	if strings.Count(string(data), "\n") != 1 {
+		t.Errorf("expected one known_hosts line, got:\n%s", data)
+	}
+	if err = callback("10.0.0.1:22", testRemote, newHostKey(t)); err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Errorf("expected changed host key error, got %v", err)
+	}
+}
+
+func TestKnownHosts_Strict(t *testing.T) {
+	dir := t.TempDir()
+	key := newHostKey(t)
+	userFile := filepath.Join(dir, "user_known_hosts")
+	line := "[10.0.0.1]:2222 " + string(ssh.MarshalAuthorizedKey(key))
+	if err := os.WriteFile(userFile, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+	hosts := NewKnownHosts([]string{userFile}, filepath.Join(dir, "known_hosts"))
+	callback, err := hosts.Callback(HostKeyStrict, "", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 2222}
+	if err = callback("10.0.0.1:2222", remote, key); err != nil {
+		t.Fatalf("key of user known_hosts should be accepted: %s", err)
+	}
+	if err = callback("10.0.0.2:22", testRemote, key); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("expected unknown host error, got %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "known_hosts")); !os.IsNotExist(err) {
+		t.Error("strict policy should not write the store")
+	}
+}
+
+func TestKnownHosts_Pinned(t *testing.T) {
+	hosts := NewKnownHosts(nil, filepath.Join(t.TempDir(), "known_hosts"))
+	key := newHostKey(t)
+	callback, err := hosts.Callback(HostKeyStrict, ssh.FingerprintSHA256(key), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = callback("10.0.0.1:22", testRemote, key); err != nil {
+		t.Fatalf("pinned key should be accepted: %s", err)
+	}
+	if err = callback("10.0.0.1:22", testRemote, newHostKey(t)); err == nil {
+		t.Error("expected error for a key not matching the pinned fingerprint")
+	}
+	if _, err = hosts.Callback("ask", "", io.Discard); err == nil {
+		t.Error("expected error for an unknown policy")
+	}
+}
+
+func TestKnownHosts_Trust(t *testing.T) {
+	dir := t.TempDir()
+	store := filepath.Join(dir, "known_hosts")
+	hosts := NewKnownHosts(nil, store)
+	callback, err := hosts.Callback(HostKeyTOFU, "", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = callback("10.0.0.1:22", testRemote, newHostKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	replaced := newHostKey(t)
+	if err = hosts.Trust("10.0.0.1:22", replaced); err != nil {
+		t.Fatal(err)
+	}
+	if err = callback("10.0.0.1:22", testRemote, replaced); err != nil {
+		t.Errorf("trusted key should replace the previous one: %s", err)
+	}
+}
+
+func TestKnownHosts_OtherKeyType(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signers []ssh.Signer
+	for _, key := range []interface{}{edKey, ecKey} {
+		signer, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, signer)
+	}
+	// the server prefers its ecdsa key, only the ed25519 one is known
+	server := serveSSH(t, signers...)
+	dir := t.TempDir()
+	store := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(server.address)}, signers[0].PublicKey()) + "\n"
+	if err = os.WriteFile(store, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+	hosts := NewKnownHosts(nil, store)
+	callback, err := hosts.Callback(HostKeyStrict, "", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	algorithms, err := hosts.HostKeyAlgorithms(server.address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(algorithms) != 1 || algorithms[0] != ssh.KeyAlgoED25519 {
+		t.Fatalf("expected the ed25519 algorithm, got %v", algorithms)
+	}
+	opts := testSSHOptions(server.address, "pw")
+	opts.HostKeyCallback = callback
+	opts.HostKeyAlgorithms = algorithms
+	conn, err := DialSSH(opts)
+	if err != nil {
+		t.Fatalf("expected the known ed25519 key presented, got %s", err)
+	}
+	conn.Close()
+
+	// offering every algorithm, the ecdsa key is taken for a changed one
+	opts.HostKeyAlgorithms = nil
+	if _, err = DialSSH(opts); err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Errorf("expected changed host key error, got %v", err)
+	}
+
+	if algorithms, err = hosts.HostKeyAlgorithms("10.0.0.9:22"); err != nil || algorithms != nil {
+		t.Errorf("expected no algorithms for an unknown host, got %v %v", algorithms, err)
+	}
+}
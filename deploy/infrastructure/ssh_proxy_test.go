@@ -0,0 +1,287 @@
+package infrastructure
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serveTarget accepts one connection, greets first as SSH servers do,
+// then echoes what it reads.
+func serveTarget(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = conn.Write([]byte("SSH-2.0-test\r\n"))
+		_, _ = io.Copy(conn, conn)
+	}()
+	return listener.Addr().String()
+}
+
+// serveHTTPProxy accepts CONNECT requests with the given credentials.
+func serveHTTPProxy(t *testing.T, credentials string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer func() { _ = conn.Close() }()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				if req.Method != http.MethodConnect || req.Header.Get("Proxy-Authorization") != credentials {
+					_, _ = conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					_, _ = conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer func() { _ = target.Close() }()
+				// the greeting of the target goes out with the response
+				greeting := make([]byte, 14)
+				_, _ = io.ReadFull(target, greeting)
+				_, _ = conn.Write(append([]byte("HTTP/1.1 200 Connection established\r\n\r\n"), greeting...))
+				go func() { _, _ = io.Copy(target, conn) }()
+				_, _ = io.Copy(conn, target)
+			}(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestDialProxy_HTTP(t *testing.T) {
+	target := serveTarget(t)
+	// base64 of deploy:secret
+	proxy := serveHTTPProxy(t, "Basic ZGVwbG95OnNlY3JldA==")
+	conn, err := dialProxy("http://deploy:secret@"+proxy, target, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+	greeting, err := reader.ReadString('\n')
+	if err != nil || greeting != "SSH-2.0-test\r\n" {
+		t.Fatalf("expected the greeting of the target, got %q, %v", greeting, err)
+	}
+	if _, err = conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	if echo, err := reader.ReadString('\n'); err != nil || echo != "ping\n" {
+		t.Fatalf("expected echo, got %q, %v", echo, err)
+	}
+}
+
+func TestDialProxy_HTTPDenied(t *testing.T) {
+	proxy := serveHTTPProxy(t, "Basic ZGVwbG95OnNlY3JldA==")
+	_, err := dialProxy("http://"+proxy, "127.0.0.1:22", 5*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Fatalf("expected 407 error, got %v", err)
+	}
+}
+
+func TestDialProxy_UnknownScheme(t *testing.T) {
+	if _, err := dialProxy("ftp://127.0.0.1:21", "127.0.0.1:22", time.Second); err == nil {
+		t.Fatal("expected unknown scheme error")
+	}
+}
+
+// serveSOCKS5 accepts CONNECT requests authenticated with user and password,
+// recording the requested addresses.
+func serveSOCKS5(t *testing.T, user, password string) (string, func() []string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	var mu sync.Mutex
+	var requested []string
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer func() { _ = conn.Close() }()
+				address, err := socks5Handshake(conn, user, password)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				requested = append(requested, address)
+				mu.Unlock()
+				target, err := net.Dial("tcp", address)
+				if err != nil {
+					_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer func() { _ = target.Close() }()
+				_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go func() { _, _ = io.Copy(target, conn) }()
+				_, _ = io.Copy(conn, target)
+			}(conn)
+		}
+	}()
+	return listener.Addr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, requested...)
+	}
+}
+
+// socks5Handshake negotiates username/password authentication, RFC 1929,
+// and returns the address of the CONNECT request.
+func socks5Handshake(conn net.Conn, user, password string) (string, error) {
+	readBytes := func(n int) ([]byte, error) {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(conn, buf)
+		return buf, err
+	}
+	header, err := readBytes(2)
+	if err != nil {
+		return "", err
+	}
+	if _, err = readBytes(int(header[1])); err != nil {
+		return "", err
+	}
+	_, _ = conn.Write([]byte{5, 2})
+	version, err := readBytes(2)
+	if err != nil {
+		return "", err
+	}
+	gotUser, err := readBytes(int(version[1]))
+	if err != nil {
+		return "", err
+	}
+	length, err := readBytes(1)
+	if err != nil {
+		return "", err
+	}
+	gotPassword, err := readBytes(int(length[0]))
+	if err != nil {
+		return "", err
+	}
+	if string(gotUser) != user || string(gotPassword) != password {
+		_, _ = conn.Write([]byte{1, 1})
+		return "", fmt.Errorf("wrong credentials")
+	}
+	_, _ = conn.Write([]byte{1, 0})
+	request, err := readBytes(4)
+	if err != nil {
+		return "", err
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip, err := readBytes(4)
+		if err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case 3:
+		length, err := readBytes(1)
+		if err != nil {
+			return "", err
+		}
+		name, err := readBytes(int(length[0]))
+		if err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", fmt.Errorf("unsupported address type: %d", request[3])
+	}
+	port, err := readBytes(2)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, fmt.Sprint(int(port[0])<<8|int(port[1]))), nil
+}
+
+func TestDialClient_SOCKS5(t *testing.T) {
+	server := serveSSH(t)
+	proxy, requested := serveSOCKS5(t, "deploy", "secret")
+	opts := testSSHOptions(server.address, "pw")
+	opts.Proxy = "socks5://deploy:secret@" + proxy
+	client, jumps, err := dialClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	if len(jumps) != 0 {
+		t.Errorf("expected no jump, got %d", len(jumps))
+	}
+	if got := requested(); len(got) != 1 || got[0] != server.address {
+		t.Errorf("expected the proxy asked for %s, got %v", server.address, got)
+	}
+
+	opts.Proxy = "socks5://deploy:wrong@" + proxy
+	if _, _, err = dialClient(opts); err == nil || !strings.Contains(err.Error(), "proxy") {
+		t.Errorf("expected proxy error, got %v", err)
+	}
+}
+
+func TestDialClient_JumpChain(t *testing.T) {
+	first, second, target := serveSSH(t), serveSSH(t), serveSSH(t)
+	firstOpts := testSSHOptions(first.address, "pw")
+	secondOpts := testSSHOptions(second.address, "pw")
+	secondOpts.Jump = &firstOpts
+	opts := testSSHOptions(target.address, "pw")
+	opts.Jump = &secondOpts
+
+	client, jumps, err := dialClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the connections to second, then to first
+	if len(jumps) != 2 {
+		t.Fatalf("expected 2 jump connections, got %d", len(jumps))
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = session.Close()
+	for _, server := range []*sshServer{first, second, target} {
+		if n := server.handshakes(); n != 1 {
+			t.Errorf("expected 1 handshake on %s, got %d", server.address, n)
+		}
+	}
+	_ = client.Close()
+	closeClients(jumps)
+
+	// a failing hop is named in the error
+	broken := testSSHOptions(second.address, "wrong")
+	broken.Jump = &firstOpts
+	opts.Jump = &broken
+	if _, _, err = dialClient(opts); err == nil || !strings.Contains(err.Error(), "jump server "+second.address) {
+		t.Errorf("expected error of jump server %s, got %v", second.address, err)
+	}
+}
@@ -1,16 +1,12 @@
 package infrastructure
 
-import (
-	"github.com/gozelle/_exec"
-)
-
 // SSHRemoteExec implements domain.RemoteExec using SSH.
 type SSHRemoteExec struct {
-	server *_exec.Server
+	server *SSHConn
 }
 
 // NewSSHRemoteExec creates a new SSHRemoteExec.
-func NewSSHRemoteExec(server *_exec.Server) *SSHRemoteExec {
+func NewSSHRemoteExec(server *SSHConn) *SSHRemoteExec {
 	return &SSHRemoteExec{server: server}
 }
 
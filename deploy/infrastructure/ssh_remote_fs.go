@@ -3,10 +3,10 @@ package infrastructure
 import (
 	"fmt"
 	"io/fs"
+	"os"
 	"strings"
 	"time"
 
-	"github.com/gozelle/_exec"
 	"github.com/koyeo/cast/deploy/domain"
 )
 
@@ -27,11 +27,11 @@ func (f *sftpFileInfo) IsDir() bool       { return f.isDir }
 
 // SSHRemoteFS implements domain.RemoteFS using SFTP and SSH.
 type SSHRemoteFS struct {
-	server *_exec.Server
+	server *SSHConn
 }
 
-// NewSSHRemoteFS creates a new SSHRemoteFS with the given exec server.
-func NewSSHRemoteFS(server *_exec.Server) *SSHRemoteFS {
+// NewSSHRemoteFS creates a new SSHRemoteFS on the given connection.
+func NewSSHRemoteFS(server *SSHConn) *SSHRemoteFS {
 	return &SSHRemoteFS{server: server}
 }
 
@@ -100,6 +100,17 @@ func (r *SSHRemoteFS) WriteFile(path string, data []byte) error {
 	return err
 }
 
+func (r *SSHRemoteFS) CreateFile(path string, data []byte) error {
+	file, err := r.server.SFTPClient().OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("create remote file error: %s", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	_, err = file.Write(data)
+	return err
+}
+
 func (r *SSHRemoteFS) Remove(path string) error {
 	// SFTP Remove only handles files; use SSH rm -rf for directories
 	info, err := r.server.SFTPClient().Stat(path)
@@ -122,6 +133,12 @@ func (r *SSHRemoteFS) Rename(src, dst string) error {
 	return r.server.SFTPClient().Rename(src, dst)
 }
 
+// Replace uses the posix-rename SFTP extension, a rename(2) on the server,
+// since a plain SFTP rename fails when dst exists.
+func (r *SSHRemoteFS) Replace(src, dst string) error {
+	return r.server.SFTPClient().PosixRename(src, dst)
+}
+
 func (r *SSHRemoteFS) FileHash(path string) (string, error) {
 	// Try sha256sum (Linux), fallback to shasum (macOS)
 	cmd := fmt.Sprintf("sha256sum %s 2>/dev/null || shasum -a 256 %s", path, path)
@@ -143,6 +160,33 @@ func (r *SSHRemoteFS) FileHash(path string) (string, error) {
 	return parts[0], nil
 }
 
+func (r *SSHRemoteFS) TreeHashes(dir string) (map[string]string, error) {
+	// One command for the whole tree, sha256sum (Linux) or shasum (macOS)
+	cmd := fmt.Sprintf("cd %s && if command -v sha256sum >/dev/null 2>&1; "+
+		"then find . -type f -exec sha256sum {} +; else find . -type f -exec shasum -a 256 {} +; fi", dir)
+	session, err := r.server.SSHClient().NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("create session error: %s", err)
+	}
+	defer func() { _ = session.Close() }()
+
+	output, err := session.Output(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("compute hashes of %s error: %s", dir, err)
+	}
+	hashes := map[string]string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		// "<hash>  ./<path>", or "<hash> *./<path>" in binary mode
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimLeft(line[i+1:], " *"), "./")
+		hashes[name] = line[:i]
+	}
+	return hashes, nil
+}
+
 func (r *SSHRemoteFS) FileModTime(path string) (time.Time, error) {
 	info, err := r.server.SFTPClient().Stat(path)
 	if err != nil {
@@ -11,10 +11,10 @@ require (
 	github.com/pterm/pterm v0.12.41
 	github.com/shopspring/decimal v1.2.0
 	github.com/spf13/cobra v1.3.0
-	github.com/ttacon/chalk v0.0.0-20160626202418-22c06c80ed31
 	github.com/webview/webview v0.0.0-20210330151455-f540d88dde4e
 	golang.org/x/crypto v0.0.0-20220427172511-eb4f295cb31f
 	golang.org/x/net v0.0.0-20211112202133-69e39bad7dc2
+	golang.org/x/term v0.0.0-20210927222741-03fcf44c2211
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
 
@@ -37,7 +37,6 @@ require (
 	github.com/tadvi/systray v0.0.0-20190226123456-11a2b8fa57af // indirect
 	github.com/xo/terminfo v0.0.0-20210125001918-ca9a967f8778 // indirect
 	golang.org/x/sys v0.0.0-20220319134239-a9b59b0215f8 // indirect
-	golang.org/x/term v0.0.0-20210927222741-03fcf44c2211 // indirect
 	gopkg.in/toast.v1 v1.0.0-20180812000517-0a84660828b2 // indirect
 )
 
@@ -4,14 +4,19 @@ import "fmt"
 
 // Message keys
 const (
-	MsgConflictFound   = "conflict_found"
-	MsgChooseAction    = "choose_action"
-	MsgBackupSuffix    = "backup_suffix"
-	MsgBackingUp       = "backing_up"
-	MsgRemoving        = "removing"
-	MsgDeployComplete  = "deploy_complete"
-	MsgSnapshotCreated = "snapshot_created"
-	MsgSnapshotUpdated = "snapshot_updated"
+	MsgConflictFound    = "conflict_found"
+	MsgChooseAction     = "choose_action"
+	MsgBackupSuffix     = "backup_suffix"
+	MsgBackingUp        = "backing_up"
+	MsgRemoving         = "removing"
+	MsgDeployComplete   = "deploy_complete"
+	MsgSnapshotCreated  = "snapshot_created"
+	MsgSnapshotUpdated  = "snapshot_updated"
+	MsgRestoring        = "restoring"
+	MsgRollbackComplete = "rollback_complete"
+	MsgSynced           = "synced"
+	MsgReleaseSwitched  = "release_switched"
+	MsgWaitingLock      = "waiting_lock"
 )
 
 var messages = map[string]map[string]string{
@@ -47,6 +52,26 @@ var messages = map[string]map[string]string{
 		"zh": "  📝 更新 snapshot: .cast/snapshot.json",
 		"en": "  📝 Updated snapshot: .cast/snapshot.json",
 	},
+	MsgRestoring: {
+		"zh": "  ⏪ 恢复: %s（记录 %s）",
+		"en": "  ⏪ Restore: %s (entry %s)",
+	},
+	MsgRollbackComplete: {
+		"zh": "  ✅ 已回滚到记录 %s",
+		"en": "  ✅ Rolled back to entry %s",
+	},
+	MsgSynced: {
+		"zh": "  🔄 同步完成：上传 %d 个文件，删除 %d 个文件",
+		"en": "  🔄 Synced: %d files uploaded, %d files deleted",
+	},
+	MsgReleaseSwitched: {
+		"zh": "  🔗 current → %s",
+		"en": "  🔗 current → %s",
+	},
+	MsgWaitingLock: {
+		"zh": "  🔒 等待部署锁释放，持有者：%s",
+		"en": "  🔒 Waiting for deploy lock held by %s",
+	},
 }
 
 // Msg returns a localized message by key and language code.
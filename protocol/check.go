@@ -0,0 +1,528 @@
+package protocol
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gozelle/_fs"
+	"gopkg.in/yaml.v3"
+)
+
+// Issue is a problem found in a config file by Check.
+type Issue struct {
+	// File is the config file of the issue, set by Check.
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (i Issue) String() string {
+	if i.Column > 0 {
+		return fmt.Sprintf("%d:%d: %s", i.Line, i.Column, i.Message)
+	}
+	return fmt.Sprintf("%d: %s", i.Line, i.Message)
+}
+
+// Check validates the config file at path without running anything:
+// unknown fields, references to servers and tasks, `use` cycles, steps and
+// mapper paths. Its includes and cast.local.yaml are checked as well. The
+// returned error is only set when a file can't be read or isn't valid YAML.
+func Check(path string) (issues []Issue, err error) {
+	ok, err := _fs.Exists(path)
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = fmt.Errorf("%s not exist", path)
+		return
+	}
+	// references may point to servers and tasks of included files
+	known, err := load(path, true)
+	if err != nil {
+		return
+	}
+	for _, file := range known.Files() {
+		var content []byte
+		content, err = _fs.Read(file)
+		if err != nil {
+			return
+		}
+		var fileIssues []Issue
+		fileIssues, err = checkContent(content, known, file)
+		if err != nil {
+			err = fmt.Errorf("%s: %s", file, err)
+			return
+		}
+		for _, issue := range fileIssues {
+			issue.File = file
+			issues = append(issues, issue)
+		}
+	}
+	return
+}
+
+// CheckContent validates a config file content, see Check.
+func CheckContent(content []byte) (issues []Issue, err error) {
+	return checkContent(content, nil, "")
+}
+
+// checkContent validates content of file, resolving references against
+// known, or the content itself when known is nil.
+func checkContent(content []byte, known *Config, file string) (issues []Issue, err error) {
+	root := &yaml.Node{}
+	if err = yaml.Unmarshal(content, root); err != nil {
+		err = fmt.Errorf("unmarshal yml error: %s", err)
+		return
+	}
+	if len(root.Content) == 0 {
+		return
+	}
+	c := &checker{root: root.Content[0], file: file}
+	c.checkFields(c.root, reflect.TypeOf(Config{}), "")
+
+	config := new(Config)
+	if decodeErr := c.root.Decode(config); decodeErr != nil {
+		if typeErr, ok := decodeErr.(*yaml.TypeError); ok {
+			for _, v := range typeErr.Errors {
+				c.addTypeError(v)
+			}
+		} else {
+			c.issues = append(c.issues, Issue{Line: c.root.Line, Column: c.root.Column, Message: decodeErr.Error()})
+		}
+	}
+	if known == nil {
+		known = config
+	}
+	c.known = known
+	c.checkConfig(config)
+
+	sort.SliceStable(c.issues, func(i, j int) bool {
+		if c.issues[i].Line != c.issues[j].Line {
+			return c.issues[i].Line < c.issues[j].Line
+		}
+		return c.issues[i].Column < c.issues[j].Column
+	})
+	issues = c.issues
+	return
+}
+
+// CheckTargetPath validates a mapper target path.
+func CheckTargetPath(target string) (err error) {
+	if !strings.HasPrefix(target, "/") && strings.HasPrefix(target, "~") {
+		err = fmt.Errorf("invilad target path: '%s'", target)
+		return
+	}
+	if strings.HasPrefix(target, "/") {
+		items := strings.Split(strings.TrimPrefix(target, "/"), "/")
+		if len(items) < 2 {
+			err = fmt.Errorf("target path: %s too sort", target)
+			return
+		}
+	}
+	return
+}
+
+type checker struct {
+	root *yaml.Node
+	// file is the path of the checked file, empty for CheckContent
+	file string
+	// known resolves references, it includes the servers and tasks of included files
+	known  *Config
+	issues []Issue
+}
+
+func (c *checker) addf(node *yaml.Node, format string, args ...interface{}) {
+	c.issues = append(c.issues, Issue{Line: node.Line, Column: node.Column, Message: fmt.Sprintf(format, args...)})
+}
+
+// addTypeError records a yaml decode error of the form "line N: message".
+func (c *checker) addTypeError(msg string) {
+	issue := Issue{Message: msg}
+	var rest string
+	if n, _ := fmt.Sscanf(msg, "line %d:", &issue.Line); n == 1 {
+		if i := strings.Index(msg, ": "); i >= 0 {
+			rest = msg[i+2:]
+		}
+		issue.Message = rest
+	}
+	c.issues = append(c.issues, issue)
+}
+
+// lookup returns the node at path, made of mapping keys and sequence
+// indexes, or the deepest node found on the way.
+func (c *checker) lookup(path ...interface{}) *yaml.Node {
+	node := c.root
+	for _, p := range path {
+		var next *yaml.Node
+		switch key := p.(type) {
+		case string:
+			if node.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if node.Content[i].Value == key {
+						next = node.Content[i+1]
+						break
+					}
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && key < len(node.Content) {
+				next = node.Content[key]
+			}
+		}
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+	return node
+}
+
+// checkFields reports mapping keys that don't match a yaml tag of t.
+func (c *checker) checkFields(node *yaml.Node, t reflect.Type, name string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if tag != "" && tag != "-" {
+				fields[tag] = t.Field(i).Type
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			if key.Value == "<<" {
+				continue
+			}
+			ft, ok := fields[key.Value]
+			if !ok {
+				if name == "" {
+					c.addf(key, "unknown field: %s", key.Value)
+				} else {
+					c.addf(key, "unknown field: %s in %s", key.Value, name)
+				}
+				continue
+			}
+			c.checkFields(node.Content[i+1], ft, key.Value)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			c.checkFields(node.Content[i+1], t.Elem(), name)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for _, item := range node.Content {
+			c.checkFields(item, t.Elem(), name)
+		}
+	}
+}
+
+// checkJump reports a jump to a server that doesn't exist, and jumps
+// leading back to a server already on the way.
+func (c *checker) checkJump(name string, server *Server) {
+	chain := []string{name}
+	for next := server.Jump; next != "" && !strings.Contains(next, "${"); {
+		if contains(chain, next) {
+			c.addf(c.lookup("servers", name, "jump"), "server: %s jump loop: %s",
+				name, strings.Join(append(chain, next), " -> "))
+			return
+		}
+		known := c.known.Servers[next]
+		if known == nil {
+			if len(chain) == 1 {
+				c.addf(c.lookup("servers", name, "jump"), "server: %s jump server: '%s' not exists", name, next)
+			}
+			return
+		}
+		chain = append(chain, next)
+		next = known.Jump
+	}
+}
+
+// checkProxy validates a proxy URL.
+func checkProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("invalid proxy: %s", err)
+	}
+	if !contains(ProxySchemes, u.Scheme) {
+		return fmt.Errorf("proxy scheme must be one of %s", strings.Join(ProxySchemes, ", "))
+	}
+	if u.Host == "" {
+		return fmt.Errorf("proxy has no host: %s", proxy)
+	}
+	return nil
+}
+
+func (c *checker) checkConfig(config *Config) {
+	for _, name := range sortedKeys(config.Servers) {
+		server := config.Servers[name]
+		if server == nil {
+			continue
+		}
+		if known := c.known.Servers[name]; known == nil || known.Host == "" && known.SSHConfig == "" {
+			c.addf(c.lookup("servers", name), "server: %s host is empty", name)
+		}
+		if server.HostKeyPolicy != "" && !contains(HostKeyPolicies, server.HostKeyPolicy) {
+			c.addf(c.lookup("servers", name, "host_key_policy"), "server: %s host_key_policy must be one of %s",
+				name, strings.Join(HostKeyPolicies, ", "))
+		}
+		for i, method := range server.Auth {
+			if !contains(AuthMethods, method) {
+				c.addf(c.lookup("servers", name, "auth", i), "server: %s auth must be one of %s",
+					name, strings.Join(AuthMethods, ", "))
+			}
+		}
+		c.checkJump(name, server)
+		if server.Proxy != "" && !strings.Contains(server.Proxy, "${") {
+			if err := checkProxy(server.Proxy); err != nil {
+				c.addf(c.lookup("servers", name, "proxy"), "server: %s %s", name, err)
+			}
+		}
+		if connect := server.Connect; connect != nil {
+			for _, field := range []struct {
+				name     string
+				negative bool
+			}{
+				{"timeout", connect.Timeout < 0},
+				{"retries", connect.Retries < 0},
+				{"retry_wait", connect.RetryWait < 0},
+				{"keep_alive_max", connect.KeepAliveMax < 0},
+			} {
+				if field.negative {
+					c.addf(c.lookup("servers", name, "connect", field.name), "server: %s connect.%s can't be negative", name, field.name)
+				}
+			}
+		}
+	}
+	for _, name := range sortedKeys(config.Tasks) {
+		task := config.Tasks[name]
+		if task == nil {
+			continue
+		}
+		c.checkParams(name, task)
+		for i, branch := range task.Branches {
+			if _, err := path.Match(branch, ""); err != nil {
+				c.addf(c.lookup("tasks", name, "branches", i), "invalid branch pattern: '%s'", branch)
+			}
+		}
+		for i, step := range task.Steps {
+			if step != nil {
+				c.checkStep(config, name, i, step)
+			}
+		}
+	}
+	c.checkCycles(c.known)
+}
+
+// defines reports whether the checked file defines task name, the last
+// definition being the one run.
+func (c *checker) defines(name string) bool {
+	origin := c.known.Origin(OriginTask, name)
+	if origin == "" || c.file == "" {
+		return true
+	}
+	abs, err := filepath.Abs(c.file)
+	return err == nil && abs == origin
+}
+
+func (c *checker) checkParams(task string, t *Task) {
+	seen := map[string]bool{}
+	for i, param := range t.Params {
+		if param == nil {
+			continue
+		}
+		path := []interface{}{"tasks", task, "params", i}
+		switch {
+		case !ValidVarName(param.Name):
+			c.addf(c.lookup(append(path, "name")...), "invalid param name: '%s'", param.Name)
+		case seen[param.Name]:
+			c.addf(c.lookup(append(path, "name")...), "duplicate param: %s", param.Name)
+		}
+		seen[param.Name] = true
+		if param.Default != "" && len(param.Values) > 0 && !contains(param.Values, param.Default) {
+			c.addf(c.lookup(append(path, "default")...), "param: %s default '%s' is not one of its values", param.Name, param.Default)
+		}
+	}
+}
+
+func (c *checker) checkStep(config *Config, task string, index int, step *Step) {
+	path := []interface{}{"tasks", task, "steps", index}
+	var kinds []string
+	if step.Use != "" {
+		kinds = append(kinds, "use")
+	}
+	if step.Run != "" {
+		kinds = append(kinds, "run")
+	}
+	if step.Deploy != nil {
+		kinds = append(kinds, "deploy")
+	}
+	switch {
+	case len(kinds) == 0:
+		c.addf(c.lookup(path...), "step has none of use, run, deploy")
+	case len(kinds) > 1:
+		c.addf(c.lookup(path...), "step has both %s", strings.Join(kinds, " and "))
+	}
+	if step.Use != "" {
+		if _, ok := c.known.Tasks[step.Use]; !ok {
+			c.addf(c.lookup(append(path, "use")...), "use task: '%s' not found", step.Use)
+		}
+	}
+	if step.Deploy != nil {
+		c.checkDeploy(config, append(path, "deploy"), step.Deploy)
+	}
+}
+
+func (c *checker) checkDeploy(config *Config, path []interface{}, deploy *Deploy) {
+	if len(deploy.Servers) == 0 {
+		c.addf(c.lookup(path...), "deploy has no servers")
+	}
+	for i, server := range deploy.Servers {
+		if server == nil {
+			continue
+		}
+		serverPath := append(append([]interface{}{}, path...), "servers", i)
+		switch {
+		case server.Use != "" && (server.Host != "" || server.SSHConfig != ""):
+			c.addf(c.lookup(serverPath...), "deploy server has both use and host")
+		case server.Use != "":
+			// references with ${VAR} are only known at run time
+			if _, ok := c.known.Servers[server.Use]; !ok && !strings.Contains(server.Use, "${") {
+				c.addf(c.lookup(append(serverPath, "use")...), "deploy use server: '%s' not exists", server.Use)
+			}
+		case server.Host == "" && server.SSHConfig == "":
+			c.addf(c.lookup(serverPath...), "deploy server host is empty")
+		}
+	}
+	// releases mappers of a target dir would flip each other's current link
+	releaseDirs := map[string]bool{}
+	for i, mapper := range deploy.Mappers {
+		if mapper == nil {
+			continue
+		}
+		mapperPath := append(append([]interface{}{}, path...), "mappers", i)
+		if mapper.Source == "" {
+			c.addf(c.lookup(mapperPath...), "mapper source is empty")
+		}
+		if mapper.Target == "" {
+			c.addf(c.lookup(mapperPath...), "mapper target is empty")
+		} else if err := CheckTargetPath(mapper.Target); err != nil {
+			c.addf(c.lookup(append(mapperPath, "target")...), "%s", err)
+		}
+		if mapper.Mode != "" && mapper.Mode != MapperModeSync {
+			c.addf(c.lookup(append(mapperPath, "mode")...), "unknown mapper mode: %s", mapper.Mode)
+		}
+		if mapper.Layout != "" && mapper.Layout != MapperLayoutReleases {
+			c.addf(c.lookup(append(mapperPath, "layout")...), "unknown mapper layout: %s", mapper.Layout)
+		}
+		if mapper.Mode == MapperModeSync && mapper.Layout == MapperLayoutReleases {
+			c.addf(c.lookup(mapperPath...), "mode: sync can't be used with layout: releases")
+		}
+		if mapper.Layout == MapperLayoutReleases && mapper.Target != "" {
+			dir := releaseDirOf(mapper.Target)
+			if releaseDirs[dir] {
+				c.addf(c.lookup(append(mapperPath, "target")...), "another layout: releases mapper already deploys into: %s", dir)
+			}
+			releaseDirs[dir] = true
+		}
+	}
+	if deploy.Parallel < 0 {
+		c.addf(c.lookup(append(path, "parallel")...), "parallel can't be negative")
+	}
+	if deploy.Strategy != nil {
+		if size := deploy.Strategy.BatchSize; size.Count < 0 {
+			c.addf(c.lookup(append(path, "strategy", "batch_size")...), "batch_size can't be negative")
+		} else if size.Percent < 0 || size.Percent > 100 {
+			c.addf(c.lookup(append(path, "strategy", "batch_size")...), "batch_size percentage must be between 0%% and 100%%")
+		}
+		if deploy.Strategy.MaxFailures < 0 {
+			c.addf(c.lookup(append(path, "strategy", "max_failures")...), "max_failures can't be negative")
+		}
+	}
+}
+
+// releaseDirOf returns the directory holding the releases and the current
+// link of a mapper target: the target itself with a trailing slash,
+// otherwise its parent.
+func releaseDirOf(target string) string {
+	if strings.HasSuffix(target, "/") {
+		return path.Clean(target)
+	}
+	return path.Dir(path.Clean(target))
+}
+
+// checkCycles reports every `use` edge closing a cycle between tasks, in
+// the file defining the task of the edge.
+func (c *checker) checkCycles(config *Config) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var stack []string
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		stack = append(stack, name)
+		task := config.Tasks[name]
+		if task != nil {
+			for i, step := range task.Steps {
+				if step == nil || step.Use == "" {
+					continue
+				}
+				if _, ok := config.Tasks[step.Use]; !ok {
+					continue
+				}
+				switch state[step.Use] {
+				case visiting:
+					cycle := []string{step.Use}
+					for j := len(stack) - 1; j >= 0 && stack[j] != step.Use; j-- {
+						cycle = append([]string{stack[j]}, cycle...)
+					}
+					cycle = append([]string{step.Use}, cycle...)
+					if c.defines(name) {
+						c.addf(c.lookup("tasks", name, "steps", i, "use"), "use cycle: %s", strings.Join(cycle, " → "))
+					}
+				case 0:
+					visit(step.Use)
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+	}
+	for _, name := range sortedKeys(config.Tasks) {
+		if state[name] == 0 {
+			visit(name)
+		}
+	}
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
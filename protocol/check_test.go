@@ -0,0 +1,458 @@
+package protocol
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func checkIssues(t *testing.T, content string) []Issue {
+	t.Helper()
+	issues, err := CheckContent([]byte(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return issues
+}
+
+func hasIssue(issues []Issue, line int, message string) bool {
+	for _, issue := range issues {
+		if issue.Line == line && strings.Contains(issue.Message, message) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheck_Valid(t *testing.T) {
+	issues := checkIssues(t, `
+servers:
+  web:
+    host: 10.0.0.1
+tasks:
+  build:
+    steps:
+      - run: make
+  deploy:
+    steps:
+      - use: build
+      - deploy:
+          servers:
+            - use: web
+          mappers:
+            - source: ./dist
+              target: /var/www/
+`)
+	if len(issues) != 0 {
+		t.Errorf("expected no issues, got %v", issues)
+	}
+}
+
+func TestCheck_UnknownField(t *testing.T) {
+	issues := checkIssues(t, `
+tasks:
+  build:
+    steps:
+      - run: make
+        comand: typo
+`)
+	if !hasIssue(issues, 6, "unknown field: comand") {
+		t.Errorf("expected unknown field issue at line 6, got %v", issues)
+	}
+	if issues[0].Column != 9 {
+		t.Errorf("expected column 9, got %d", issues[0].Column)
+	}
+}
+
+func TestCheck_References(t *testing.T) {
+	issues := checkIssues(t, `
+tasks:
+  deploy:
+    steps:
+      - use: bulid
+      - run: make
+        use: deploy
+      - deploy:
+          servers:
+            - use: wbe
+          mappers:
+            - target: /www
+`)
+	for _, want := range []struct {
+		line    int
+		message string
+	}{
+		{5, "use task: 'bulid' not found"},
+		{6, "step has both use and run"},
+		{10, "deploy use server: 'wbe' not exists"},
+		{12, "mapper source is empty"},
+		{12, "too sort"},
+	} {
+		if !hasIssue(issues, want.line, want.message) {
+			t.Errorf("expected %q at line %d, got %v", want.message, want.line, issues)
+		}
+	}
+}
+
+func TestCheck_IndirectCycle(t *testing.T) {
+	issues := checkIssues(t, `
+tasks:
+  a:
+    steps:
+      - use: b
+  b:
+    steps:
+      - use: c
+  c:
+    steps:
+      - use: a
+`)
+	if len(issues) != 1 || !hasIssue(issues, 11, "use cycle: a → b → c → a") {
+		t.Errorf("expected one cycle issue at line 11, got %v", issues)
+	}
+}
+
+func TestCheck_TypeError(t *testing.T) {
+	issues := checkIssues(t, `
+tasks:
+  deploy:
+    steps:
+      - deploy:
+          parallel: many
+          servers:
+            - host: 10.0.0.1
+`)
+	if !hasIssue(issues, 6, "cannot unmarshal") {
+		t.Errorf("expected type error at line 6, got %v", issues)
+	}
+}
+
+func TestCheck_Params(t *testing.T) {
+	issues := checkIssues(t, `
+tasks:
+  deploy:
+    params:
+      - name: version
+      - name: version
+      - name: env
+        default: dev
+        values: [staging, prod]
+      - name: 1x
+    steps:
+      - run: echo $version
+`)
+	if !hasIssue(issues, 6, "duplicate param: version") {
+		t.Errorf("expected duplicate param issue, got %v", issues)
+	}
+	if !hasIssue(issues, 8, "default 'dev' is not one of its values") {
+		t.Errorf("expected default issue, got %v", issues)
+	}
+	if !hasIssue(issues, 10, "invalid param name") {
+		t.Errorf("expected invalid name issue, got %v", issues)
+	}
+}
+
+func TestCheck_BranchPattern(t *testing.T) {
+	issues := checkIssues(t, `
+tasks:
+  deploy:
+    branches: [main, "release/[0-9"]
+    steps:
+      - run: make
+`)
+	if !hasIssue(issues, 4, "invalid branch pattern: 'release/[0-9'") {
+		t.Errorf("expected branch pattern issue, got %v", issues)
+	}
+}
+
+func TestCheck_HostKeyPolicy(t *testing.T) {
+	issues := checkIssues(t, `
+servers:
+  web:
+    host: 10.0.0.1
+    host_key_policy: ask
+tasks:
+  deploy:
+    steps:
+      - run: make
+`)
+	if !hasIssue(issues, 5, "host_key_policy must be one of strict, tofu, off") {
+		t.Errorf("expected host_key_policy issue, got %v", issues)
+	}
+}
+
+func TestCheck_AuthMethods(t *testing.T) {
+	issues := checkIssues(t, `
+servers:
+  web:
+    host: 10.0.0.1
+    auth: [agent, otp]
+tasks:
+  deploy:
+    steps:
+      - run: make
+`)
+	if !hasIssue(issues, 5, "auth must be one of agent, publickey, password, keyboard-interactive") {
+		t.Errorf("expected auth issue, got %v", issues)
+	}
+}
+
+func TestCheck_SSHConfigServer(t *testing.T) {
+	issues := checkIssues(t, `
+servers:
+  web:
+    ssh_config: web
+tasks:
+  deploy:
+    steps:
+      - deploy:
+          servers:
+            - use: web
+            - ssh_config: db
+          mappers:
+            - source: ./dist
+              target: /srv/app
+`)
+	if len(issues) != 0 {
+		t.Errorf("expected no issues, got %v", issues)
+	}
+}
+
+func TestCheck_JumpAndProxy(t *testing.T) {
+	issues := checkIssues(t, `
+servers:
+  bastion:
+    host: 10.0.0.1
+    proxy: socks5://127.0.0.1:1080
+  web:
+    host: 10.0.1.1
+    jump: bastion
+  a:
+    host: 10.0.1.2
+    jump: b
+  b:
+    host: 10.0.1.3
+    jump: a
+  db:
+    host: 10.0.1.4
+    jump: gateway
+    proxy: ftp://proxy
+tasks:
+  deploy:
+    steps:
+      - run: make
+`)
+	for _, want := range []struct {
+		line    int
+		message string
+	}{
+		{11, "server: a jump loop: a -> b -> a"},
+		{14, "server: b jump loop: b -> a -> b"},
+		{17, "server: db jump server: 'gateway' not exists"},
+		{18, "proxy scheme must be one of socks5, socks5h, http"},
+	} {
+		if !hasIssue(issues, want.line, want.message) {
+			t.Errorf("expected issue at line %d: %s, got %v", want.line, want.message, issues)
+		}
+	}
+	if len(issues) != 4 {
+		t.Errorf("expected 4 issues, got %v", issues)
+	}
+}
+
+func TestCheck_Connect(t *testing.T) {
+	issues := checkIssues(t, `
+servers:
+  web:
+    host: 10.0.0.1
+    connect:
+      timeout: 10s
+      retries: -1
+      keep_alive: -1s
+      keep_alive_max: -2
+tasks:
+  deploy:
+    steps:
+      - run: make
+`)
+	for _, want := range []struct {
+		line    int
+		message string
+	}{
+		{7, "server: web connect.retries can't be negative"},
+		{9, "server: web connect.keep_alive_max can't be negative"},
+	} {
+		if !hasIssue(issues, want.line, want.message) {
+			t.Errorf("expected issue at line %d: %s, got %v", want.line, want.message, issues)
+		}
+	}
+	if len(issues) != 2 {
+		t.Errorf("expected 2 issues, got %v", issues)
+	}
+}
+
+func TestCheck_BatchSize(t *testing.T) {
+	issues := checkIssues(t, `
+servers:
+  web:
+    host: 10.0.0.1
+tasks:
+  a:
+    steps:
+      - deploy:
+          servers:
+            - use: web
+          strategy:
+            batch_size: 25%
+  b:
+    steps:
+      - deploy:
+          servers:
+            - use: web
+          strategy:
+            batch_size: 150%
+  c:
+    steps:
+      - deploy:
+          servers:
+            - use: web
+          strategy:
+            batch_size: half
+`)
+	for _, want := range []struct {
+		line    int
+		message string
+	}{
+		{19, "batch_size percentage must be between 0% and 100%"},
+		{26, "batch_size must be a number or a percentage, e.g. 25%, got: half"},
+	} {
+		if !hasIssue(issues, want.line, want.message) {
+			t.Errorf("expected issue at line %d: %s, got %v", want.line, want.message, issues)
+		}
+	}
+	if len(issues) != 2 {
+		t.Errorf("expected 2 issues, got %v", issues)
+	}
+}
+
+func TestCheck_ReleasesSharingTarget(t *testing.T) {
+	issues := checkIssues(t, `
+servers:
+  web:
+    host: 10.0.0.1
+tasks:
+  a:
+    steps:
+      - deploy:
+          servers:
+            - use: web
+          mappers:
+            - source: ./api
+              target: /srv/app/
+              layout: releases
+            - source: ./web
+              target: /srv/app/web
+              layout: releases
+            - source: ./docs
+              target: /srv/docs/
+              layout: releases
+            - source: ./conf
+              target: /srv/app/conf
+`)
+	want := "another layout: releases mapper already deploys into: /srv/app"
+	if !hasIssue(issues, 16, want) {
+		t.Errorf("expected issue at line 16: %s, got %v", want, issues)
+	}
+	if len(issues) != 1 {
+		t.Errorf("expected 1 issue, got %v", issues)
+	}
+}
+
+func TestCheck_IncludedFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	shared := filepath.Join(dir, "shared.yaml")
+	root := filepath.Join(dir, "cast.yaml")
+	local := filepath.Join(dir, "cast.local.yaml")
+	writeFile(t, shared, `servers:
+  web:
+    host: 10.0.0.1
+    prot: 22
+    port: ssh
+tasks:
+  build:
+    steps:
+      - run: make
+`)
+	writeFile(t, root, `include: [shared.yaml]
+tasks:
+  deploy:
+    steps:
+      - use: build
+`)
+	writeFile(t, local, `servers:
+  web:
+    pasword: secret
+`)
+
+	issues, err := Check(root)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	has := func(file string, line int, message string) bool {
+		for _, issue := range issues {
+			if issue.File == file && issue.Line == line && strings.Contains(issue.Message, message) {
+				return true
+			}
+		}
+		return false
+	}
+	if !has(shared, 4, "unknown field: prot") {
+		t.Errorf("expected unknown field prot in %s, got %v", shared, issues)
+	}
+	if !has(shared, 5, "ssh") {
+		t.Errorf("expected type error of port in %s, got %v", shared, issues)
+	}
+	if !has(local, 3, "unknown field: pasword") {
+		t.Errorf("expected unknown field pasword in %s, got %v", local, issues)
+	}
+	if len(issues) != 3 {
+		t.Errorf("expected 3 issues, got %v", issues)
+	}
+}
+
+func TestCheck_IncludedCycle(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	shared := filepath.Join(dir, "shared.yaml")
+	root := filepath.Join(dir, "cast.yaml")
+	writeFile(t, shared, `tasks:
+  build:
+    steps:
+      - run: make
+      - use: app
+  test:
+    steps:
+      - use: missing
+`)
+	writeFile(t, root, `include: [shared.yaml]
+tasks:
+  app:
+    steps:
+      - use: build
+`)
+
+	issues, err := Check(root)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(issues) != 2 {
+		t.Fatalf("expected 2 issues, got %v", issues)
+	}
+	if issue := issues[0]; issue.File != shared || issue.Line != 5 || !strings.Contains(issue.Message, "use cycle: app → build → app") {
+		t.Errorf("expected the cycle at the use of build in %s, got %s:%s", shared, issue.File, issue)
+	}
+	if issue := issues[1]; issue.File != shared || issue.Line != 8 || !strings.Contains(issue.Message, "use task: 'missing' not found") {
+		t.Errorf("expected the missing task at its use in %s, got %s:%s", shared, issue.File, issue)
+	}
+}
@@ -0,0 +1,213 @@
+package protocol
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Lookup returns the value of a variable and whether it is defined.
+type Lookup func(name string) (string, bool)
+
+// Expand replaces ${NAME} and ${NAME:-default} in s using lookup. The
+// default is used when the variable is undefined or empty, an undefined
+// variable without a default is an error. $${ is kept as a literal ${,
+// other $ are left alone for the shell.
+func Expand(s string, lookup Lookup) (string, error) {
+	return expand(s, lookup, false)
+}
+
+// ExpandDefined replaces the ${NAME} and ${NAME:-default} of s whose
+// variable lookup defines, as Expand does, leaving every other ${...} to
+// the shell the command runs in.
+func ExpandDefined(s string, lookup Lookup) (string, error) {
+	return expand(s, lookup, true)
+}
+
+// expand implements Expand, or ExpandDefined when keep is set.
+func expand(s string, lookup Lookup, keep bool) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i])
+			b.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+		end := strings.Index(s[i:], "}")
+		if end < 0 {
+			if keep {
+				b.WriteString(s[i:])
+				return b.String(), nil
+			}
+			return "", fmt.Errorf("unterminated variable: %s", s[i:])
+		}
+		expr := s[i+2 : i+end]
+		name, def, hasDefault := expr, "", false
+		if j := strings.Index(expr, ":-"); j >= 0 {
+			name, def, hasDefault = expr[:j], expr[j+2:], true
+		}
+		value, ok := lookup(name)
+		switch {
+		case keep && (!ValidVarName(name) || !ok):
+			b.WriteString(s[i : i+end+1])
+		case !ValidVarName(name):
+			return "", fmt.Errorf("invalid variable: ${%s}", expr)
+		case ok && (value != "" || !hasDefault):
+			b.WriteString(value)
+		case hasDefault:
+			b.WriteString(def)
+		default:
+			return "", fmt.Errorf("undefined variable: ${%s}", name)
+		}
+		s = s[i+end+1:]
+	}
+}
+
+// ValidVarName reports whether name is a valid shell variable name.
+func ValidVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// ParseVars parses KEY=VALUE pairs passed with --set.
+func ParseVars(pairs []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i <= 0 || !ValidVarName(pair[:i]) {
+			return nil, fmt.Errorf("invalid --set: %s, expect KEY=VALUE", pair)
+		}
+		vars[pair[:i]] = pair[i+1:]
+	}
+	return vars, nil
+}
+
+// Interpolate returns a copy of the deploy with variables expanded in
+// its servers, mappers and executes. The commands of executes, run by a
+// remote shell, only expand the variables shell defines, see
+// ExpandDefined.
+func (p *Deploy) Interpolate(lookup, shell Lookup) (*Deploy, error) {
+	v, err := interpolate(reflect.ValueOf(p), expander{lookup: lookup, shell: shell}, "deploy")
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface().(*Deploy), nil
+}
+
+// Interpolate returns a copy of the server with variables expanded.
+func (p *Server) Interpolate(lookup Lookup) (*Server, error) {
+	v, err := interpolate(reflect.ValueOf(p), expander{lookup: lookup}, "server")
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface().(*Server), nil
+}
+
+// expander expands the strings of a config value with lookup, and those
+// of fields tagged `expand:"shell"` with shell, see ExpandDefined.
+type expander struct {
+	lookup Lookup
+	shell  Lookup
+	// keep is set inside shell fields
+	keep bool
+}
+
+func (e expander) expand(s string) (string, error) {
+	if e.keep {
+		return ExpandDefined(s, e.shell)
+	}
+	return Expand(s, e.lookup)
+}
+
+// interpolate deep copies v, expanding every string. path locates
+// the value in error messages, e.g. deploy.mappers[0].target.
+func interpolate(v reflect.Value, e expander, path string) (reflect.Value, error) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v, nil
+		}
+		elem, err := interpolate(v.Elem(), e, path)
+		if err != nil {
+			return v, err
+		}
+		ptr := reflect.New(v.Type().Elem())
+		ptr.Elem().Set(elem)
+		return ptr, nil
+	case reflect.Struct:
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "" {
+				name = field.Name
+			}
+			fieldExpander := e
+			if field.Tag.Get("expand") == "shell" && e.shell != nil {
+				fieldExpander.keep = true
+			}
+			value, err := interpolate(v.Field(i), fieldExpander, path+"."+name)
+			if err != nil {
+				return v, err
+			}
+			copied.Field(i).Set(value)
+		}
+		return copied, nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return v, nil
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			value, err := interpolate(v.Index(i), e, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return v, err
+			}
+			copied.Index(i).Set(value)
+		}
+		return copied, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return v, nil
+		}
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			value, err := interpolate(iter.Value(), e, fmt.Sprintf("%s.%v", path, iter.Key()))
+			if err != nil {
+				return v, err
+			}
+			copied.SetMapIndex(iter.Key(), value)
+		}
+		return copied, nil
+	case reflect.String:
+		expanded, err := e.expand(v.String())
+		if err != nil {
+			return v, fmt.Errorf("%s: %s", path, err)
+		}
+		return reflect.ValueOf(expanded).Convert(v.Type()), nil
+	}
+	return v, nil
+}
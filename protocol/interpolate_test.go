@@ -0,0 +1,114 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+)
+
+func lookupMap(vars map[string]string) Lookup {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestExpand(t *testing.T) {
+	lookup := lookupMap(map[string]string{"VERSION": "1.2", "EMPTY": ""})
+	cases := map[string]string{
+		"app-${VERSION}.tar.gz": "app-1.2.tar.gz",
+		"${TARGET:-staging}":    "staging",
+		"${EMPTY:-fallback}":    "fallback",
+		"${EMPTY}":              "",
+		"echo $${HOME} $PATH":   "echo ${HOME} $PATH",
+		"${VERSION}/${VERSION}": "1.2/1.2",
+	}
+	for in, want := range cases {
+		got, err := Expand(in, lookup)
+		if err != nil {
+			t.Fatalf("%s: %s", in, err)
+		}
+		if got != want {
+			t.Errorf("%s: expected %q, got %q", in, want, got)
+		}
+	}
+	for _, in := range []string{"${MISSING}", "${VERSION", "${1X}"} {
+		if _, err := Expand(in, lookup); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+}
+
+func TestDeployInterpolate(t *testing.T) {
+	deploy := &Deploy{
+		Servers: []*Server{{Use: "${TARGET}"}},
+		Mappers: []*Mapper{{Source: "./app-${VERSION}", Target: "/opt/${NAME}/"}},
+	}
+	_, err := deploy.Interpolate(lookupMap(map[string]string{"TARGET": "prod", "VERSION": "1"}), nil)
+	if err == nil || !strings.Contains(err.Error(), "deploy.mappers[0].target") {
+		t.Fatalf("expected error locating deploy.mappers[0].target, got %v", err)
+	}
+
+	expanded, err := deploy.Interpolate(lookupMap(map[string]string{"TARGET": "prod", "VERSION": "1", "NAME": "app"}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expanded.Servers[0].Use != "prod" || expanded.Mappers[0].Source != "./app-1" || expanded.Mappers[0].Target != "/opt/app/" {
+		t.Errorf("unexpected expansion: %+v %+v", expanded.Servers[0], expanded.Mappers[0])
+	}
+	if deploy.Mappers[0].Source != "./app-${VERSION}" {
+		t.Error("original deploy should be left unchanged")
+	}
+}
+
+func TestExpandDefined(t *testing.T) {
+	lookup := lookupMap(map[string]string{"VERSION": "1.2", "EMPTY": ""})
+	cases := map[string]string{
+		"echo ${VERSION} ${HOME}":   "echo 1.2 ${HOME}",
+		"${EMPTY:-fallback}":        "fallback",
+		"${TARGET:-staging}":        "${TARGET:-staging}",
+		"echo ${#PATH} ${PATH%%:*}": "echo ${#PATH} ${PATH%%:*}",
+		"echo $${VERSION}":          "echo ${VERSION}",
+		"echo ${VERSION":            "echo ${VERSION",
+	}
+	for in, want := range cases {
+		got, err := ExpandDefined(in, lookup)
+		if err != nil {
+			t.Fatalf("%s: %s", in, err)
+		}
+		if got != want {
+			t.Errorf("%s: expected %q, got %q", in, want, got)
+		}
+	}
+}
+
+func TestDeployInterpolate_Executes(t *testing.T) {
+	deploy := &Deploy{
+		Mappers:  []*Mapper{{Source: "./dist", Target: "${HOME}/app/"}},
+		Executes: []*Execute{{Run: "cd ${HOME}/app && ./run ${VERSION}", Workdir: "${HOME}"}},
+	}
+	local := lookupMap(map[string]string{"HOME": "/home/me", "VERSION": "1"})
+	declared := lookupMap(map[string]string{"VERSION": "1"})
+	expanded, err := deploy.Interpolate(local, declared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run := expanded.Executes[0].Run; run != "cd ${HOME}/app && ./run 1" {
+		t.Errorf("expected ${HOME} left to the remote shell, got %q", run)
+	}
+	if expanded.Mappers[0].Target != "/home/me/app/" || expanded.Executes[0].Workdir != "/home/me" {
+		t.Errorf("expected the other fields expanded, got %+v %+v", expanded.Mappers[0], expanded.Executes[0])
+	}
+}
+
+func TestParseVars(t *testing.T) {
+	vars, err := ParseVars([]string{"A=1", "B=x=y", "C="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["A"] != "1" || vars["B"] != "x=y" || vars["C"] != "" {
+		t.Errorf("unexpected vars: %v", vars)
+	}
+	if _, err = ParseVars([]string{"=1"}); err == nil {
+		t.Error("expected error for empty name")
+	}
+}
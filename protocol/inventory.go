@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/koyeo/cast/common"
+	"gopkg.in/yaml.v3"
+)
+
+const inventoryFile = "servers.yaml"
+
+// Inventory is the user-global server inventory stored at
+// ~/.cast/servers.yaml. Projects reference its servers with `use`,
+// their own servers and envs taking priority.
+type Inventory struct {
+	Servers map[string]*Server `yaml:"servers,omitempty"`
+	Envs    map[string]string  `yaml:"envs,omitempty"`
+}
+
+// InventoryPath returns the path of the user-global server inventory.
+func InventoryPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("get home dir error: %s", err)
+	}
+	return filepath.Join(home, common.TmpWorkspace, inventoryFile), nil
+}
+
+// LoadInventory reads the user-global server inventory,
+// an empty one if it doesn't exist yet.
+func LoadInventory() (*Inventory, error) {
+	inventory := &Inventory{}
+	path, err := InventoryPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return inventory, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read inventory error: %s", err)
+	}
+	if err = yaml.Unmarshal(data, inventory); err != nil {
+		return nil, fmt.Errorf("unmarshal %s error: %s", path, err)
+	}
+	return inventory, nil
+}
+
+// Save writes the inventory, readable by the user only as it may hold passwords.
+func (p *Inventory) Save() error {
+	path, err := InventoryPath()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create inventory dir error: %s", err)
+	}
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("marshal inventory error: %s", err)
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// fillFrom adds the servers and envs of the inventory the config doesn't define.
+func (p *Config) fillFrom(inventory *Inventory) {
+	path, _ := InventoryPath()
+	for k, v := range inventory.Servers {
+		if _, ok := p.Servers[k]; ok {
+			continue
+		}
+		if p.Servers == nil {
+			p.Servers = map[string]*Server{}
+		}
+		p.Servers[k] = v
+		p.setOrigin(OriginServer, k, path)
+	}
+	for k, v := range inventory.Envs {
+		if _, ok := p.Envs[k]; ok {
+			continue
+		}
+		if p.Envs == nil {
+			p.Envs = map[string]string{}
+		}
+		p.Envs[k] = v
+		p.setOrigin(OriginEnv, k, path)
+	}
+}
@@ -3,10 +3,76 @@ package protocol
 import (
 	"fmt"
 	"github.com/gozelle/_fs"
+	"github.com/koyeo/cast/common"
 	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 )
 
+// Load loads the config file at path, located with Locate when path is
+// empty. Relative paths in the config resolve against its directory.
+//
+// Files listed in `include:` are merged first, in order, then the file
+// itself, then an optional cast.local.yaml next to it. Servers, tasks and
+// envs defined later replace those with the same name, except that fields
+// set on a server are overlaid onto the earlier definition. Servers and
+// envs of the user inventory (~/.cast/servers.yaml) fill in the rest.
 func Load(path string) (config *Config, err error) {
+	return load(path, false)
+}
+
+// load loads the config as Load does. When lenient, fields of the wrong
+// type are left empty instead of failing, for Check to report them.
+func load(path string, lenient bool) (config *Config, err error) {
+	path, err = Locate(path)
+	if err != nil {
+		return
+	}
+	config = &Config{}
+	if err = config.merge(path, map[string]bool{}, lenient); err != nil {
+		return
+	}
+	config.Dir, err = filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	local := filepath.Join(filepath.Dir(path), common.LocalConfigFile)
+	if filepath.Base(path) != common.LocalConfigFile {
+		var ok bool
+		ok, err = _fs.Exists(local)
+		if err != nil {
+			return
+		}
+		if ok {
+			if err = config.merge(local, map[string]bool{}, lenient); err != nil {
+				return
+			}
+		}
+	}
+	inventory, err := LoadInventory()
+	if err != nil {
+		return
+	}
+	config.fillFrom(inventory)
+	return
+}
+
+// merge loads the file at path with its includes into p.
+// including holds the files being loaded, to detect include cycles.
+func (p *Config) merge(path string, including map[string]bool, lenient bool) (err error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+	if including[abs] {
+		err = fmt.Errorf("include cycle: %s includes itself", path)
+		return
+	}
+	including[abs] = true
+	defer delete(including, abs)
+
 	ok, err := _fs.Exists(path)
 	if err != nil {
 		return
@@ -15,16 +81,110 @@ func Load(path string) (config *Config, err error) {
 		err = fmt.Errorf("%s not exist", path)
 		return
 	}
-
 	content, err := _fs.Read(path)
 	if err != nil {
 		return
 	}
-	config = new(Config)
-	err = yaml.Unmarshal(content, config)
+	p.files = append(p.files, path)
+	file := new(Config)
+	err = yaml.Unmarshal(content, file)
+	if _, ok := err.(*yaml.TypeError); ok && lenient {
+		err = nil
+	}
 	if err != nil {
-		err = fmt.Errorf("unmarshal yml error: %s", err)
+		err = fmt.Errorf("unmarshal %s error: %s", path, err)
 		return
 	}
+	for _, include := range file.Include {
+		include, err = expandHome(include)
+		if err != nil {
+			return
+		}
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		if err = p.merge(include, including, lenient); err != nil {
+			return
+		}
+	}
+
+	if file.Version != "" {
+		p.Version = file.Version
+	}
+	for k, v := range file.Servers {
+		if p.Servers == nil {
+			p.Servers = map[string]*Server{}
+		}
+		p.Servers[k] = mergeServer(p.Servers[k], v)
+		p.setOrigin(OriginServer, k, abs)
+	}
+	for k, v := range file.Envs {
+		if p.Envs == nil {
+			p.Envs = map[string]string{}
+		}
+		p.Envs[k] = v
+		p.setOrigin(OriginEnv, k, abs)
+	}
+	p.LocalEnvs = append(p.LocalEnvs, file.LocalEnvs...)
+	for k, v := range file.Tasks {
+		if p.Tasks == nil {
+			p.Tasks = map[string]*Task{}
+		}
+		p.Tasks[k] = v
+		p.setOrigin(OriginTask, k, abs)
+	}
 	return
 }
+
+// Origin kinds, see Config.Origin.
+const (
+	OriginServer = "servers"
+	OriginEnv    = "envs"
+	OriginTask   = "tasks"
+)
+
+// Files returns the config files read by Load: the file itself, its
+// includes and cast.local.yaml.
+func (p Config) Files() []string {
+	return p.files
+}
+
+// Origin returns the file that defined the server, env or task name.
+func (p Config) Origin(kind, name string) string {
+	return p.origins[kind+"."+name]
+}
+
+func (p *Config) setOrigin(kind, name, path string) {
+	if p.origins == nil {
+		p.origins = map[string]string{}
+	}
+	p.origins[kind+"."+name] = path
+}
+
+// mergeServer overlays the fields set in server onto base, so an overlay
+// can add e.g. a password to a server defined in an included file.
+func mergeServer(base, server *Server) *Server {
+	if base == nil || server == nil {
+		return server
+	}
+	merged := *base
+	src := reflect.ValueOf(server).Elem()
+	dst := reflect.ValueOf(&merged).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if !src.Field(i).IsZero() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	return &merged
+}
+
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("get home dir error: %s", err)
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~")), nil
+}
@@ -0,0 +1,102 @@
+package protocol
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad_IncludeAndLocal(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	writeFile(t, filepath.Join(dir, "shared", "servers.yaml"), `
+servers:
+  web:
+    host: 10.0.0.1
+    user: deploy
+envs:
+  STAGE: shared
+`)
+	writeFile(t, filepath.Join(dir, "app", "cast.yaml"), `
+include:
+  - ../shared/servers.yaml
+envs:
+  STAGE: app
+tasks:
+  deploy:
+    steps:
+      - run: make
+`)
+	writeFile(t, filepath.Join(dir, "app", "cast.local.yaml"), `
+servers:
+  web:
+    password: secret
+`)
+
+	conf, err := Load(filepath.Join(dir, "app", "cast.yaml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	web := conf.Servers["web"]
+	if web == nil || web.Host != "10.0.0.1" || web.User != "deploy" || web.Password != "secret" {
+		t.Errorf("expected local password overlaid on the included server, got %+v", web)
+	}
+	if conf.Envs["STAGE"] != "app" {
+		t.Errorf("expected the file to override its includes, got %s", conf.Envs["STAGE"])
+	}
+	if got := conf.Origin(OriginServer, "web"); got != filepath.Join(dir, "app", "cast.local.yaml") {
+		t.Errorf("unexpected server origin: %s", got)
+	}
+	if got := conf.Origin(OriginTask, "deploy"); got != filepath.Join(dir, "app", "cast.yaml") {
+		t.Errorf("unexpected task origin: %s", got)
+	}
+}
+
+func TestLoad_IncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	writeFile(t, filepath.Join(dir, "cast.yaml"), "include: [a.yaml]\n")
+	writeFile(t, filepath.Join(dir, "a.yaml"), "include: [cast.yaml]\n")
+
+	if _, err := Load(filepath.Join(dir, "cast.yaml")); err == nil {
+		t.Fatal("expected include cycle error")
+	}
+}
+
+func TestLoad_Inventory(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	inventory := &Inventory{Servers: map[string]*Server{
+		"web": {Host: "10.0.0.1", Password: "global"},
+		"db":  {Host: "10.0.0.2"},
+	}}
+	if err := inventory.Save(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	writeFile(t, filepath.Join(dir, "cast.yaml"), `
+servers:
+  web:
+    host: 10.0.0.5
+`)
+
+	conf, err := Load(filepath.Join(dir, "cast.yaml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if web := conf.Servers["web"]; web.Host != "10.0.0.5" || web.Password != "" {
+		t.Errorf("expected project server to take priority, got %+v", web)
+	}
+	if db := conf.Servers["db"]; db == nil || db.Host != "10.0.0.2" {
+		t.Errorf("expected inventory server to be usable, got %+v", db)
+	}
+}
@@ -0,0 +1,34 @@
+package protocol
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/koyeo/cast/common"
+)
+
+// Locate returns the config file to load: file if given, else $CAST_FILE,
+// else the nearest cast.yaml in the working directory or its parents.
+func Locate(file string) (string, error) {
+	if file != "" {
+		return file, nil
+	}
+	if env := os.Getenv(common.ConfigFileEnv); env != "" {
+		return env, nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("get working directory error: %s", err)
+	}
+	for dir := wd; ; dir = filepath.Dir(dir) {
+		candidate := filepath.Join(dir, common.DefaultConfigFile)
+		if info, statErr := os.Stat(candidate); statErr == nil && !info.IsDir() {
+			return candidate, nil
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	return "", fmt.Errorf("%s not found in %s or any parent directory", common.DefaultConfigFile, wd)
+}
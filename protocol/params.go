@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseTaskArg splits a `cast run` argument of the form
+// task:name=value,name=value into the task name and its param values.
+func ParseTaskArg(arg string) (name string, args map[string]string, err error) {
+	i := strings.Index(arg, ":")
+	if i < 0 {
+		return arg, nil, nil
+	}
+	name = arg[:i]
+	args = map[string]string{}
+	for _, pair := range strings.Split(arg[i+1:], ",") {
+		j := strings.Index(pair, "=")
+		if j <= 0 || !ValidVarName(pair[:j]) {
+			return "", nil, fmt.Errorf("invalid task param: %s, expect %s:name=value", pair, name)
+		}
+		args[pair[:j]] = pair[j+1:]
+	}
+	return
+}
+
+// Param returns the param declared with name, nil if there's none.
+func (p Task) Param(name string) *Param {
+	for _, param := range p.Params {
+		if param != nil && param.Name == name {
+			return param
+		}
+	}
+	return nil
+}
+
+// ResolveParams returns the value of every param of the task that is set,
+// taken from the first of inputs defining it, otherwise from its default.
+// Missing required params and values not allowed are errors.
+func (p Task) ResolveParams(inputs ...map[string]string) (map[string]string, error) {
+	params := map[string]string{}
+	for _, param := range p.Params {
+		if param == nil {
+			continue
+		}
+		value, ok := param.Default, param.Default != ""
+		for _, input := range inputs {
+			if v, set := input[param.Name]; set {
+				value, ok = v, true
+				break
+			}
+		}
+		if !ok || value == "" {
+			if param.Required {
+				return nil, fmt.Errorf("param: %s is required, set it with --set %s=<value>", param.Name, param.Name)
+			}
+			if !ok {
+				continue
+			}
+		}
+		if len(param.Values) > 0 && !contains(param.Values, value) {
+			return nil, fmt.Errorf("param: %s must be one of %s, got '%s'", param.Name, strings.Join(param.Values, ", "), value)
+		}
+		params[param.Name] = value
+	}
+	return params, nil
+}
+
+func contains(items []string, item string) bool {
+	for _, v := range items {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTaskArg(t *testing.T) {
+	name, args, err := ParseTaskArg("deploy:version=1.4.2,env=prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "deploy" || args["version"] != "1.4.2" || args["env"] != "prod" {
+		t.Errorf("unexpected result: %s %v", name, args)
+	}
+	name, args, err = ParseTaskArg("deploy")
+	if err != nil || name != "deploy" || args != nil {
+		t.Errorf("unexpected result: %s %v %v", name, args, err)
+	}
+	if _, _, err = ParseTaskArg("deploy:version"); err == nil {
+		t.Error("expected error for a param without value")
+	}
+}
+
+func TestResolveParams(t *testing.T) {
+	task := Task{Params: []*Param{
+		{Name: "version", Required: true},
+		{Name: "env", Default: "staging", Values: []string{"staging", "prod"}},
+		{Name: "note"},
+	}}
+
+	params, err := task.ResolveParams(map[string]string{"version": "1.4.2"}, map[string]string{"version": "1.0", "env": "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["version"] != "1.4.2" || params["env"] != "prod" {
+		t.Errorf("unexpected params: %v", params)
+	}
+	if _, ok := params["note"]; ok {
+		t.Error("unset param without default should be left out")
+	}
+
+	params, err = task.ResolveParams(nil, map[string]string{"version": "1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["env"] != "staging" {
+		t.Errorf("expected default env, got %v", params)
+	}
+
+	if _, err = task.ResolveParams(nil); err == nil || !strings.Contains(err.Error(), "version is required") {
+		t.Errorf("expected required error, got %v", err)
+	}
+	if _, err = task.ResolveParams(map[string]string{"version": "1", "env": "dev"}); err == nil || !strings.Contains(err.Error(), "must be one of") {
+		t.Errorf("expected allowed values error, got %v", err)
+	}
+}
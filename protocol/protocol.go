@@ -1,31 +1,117 @@
 package protocol
 
-import "fmt"
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
 
 const (
 	Version = "1.0"
 )
 
 type Config struct {
-	Version string             `yaml:"version"`
+	Version string `yaml:"version"`
+	// Include lists YAML files merged before this one, relative to it.
+	Include []string           `yaml:"include"`
 	Servers map[string]*Server `yaml:"servers"`
 	Envs    map[string]string  `yaml:"envs"`
-	Tasks   map[string]*Task   `yaml:"tasks"`
+	// LocalEnvs names envs kept out of remote executes, e.g. secrets
+	// only local commands need.
+	LocalEnvs []string         `yaml:"local_envs"`
+	Tasks     map[string]*Task `yaml:"tasks"`
+	// Dir is the directory of the loaded config file.
+	Dir string `yaml:"-"`
+	// Vars are values passed with --set, overriding envs.
+	Vars map[string]string `yaml:"-"`
+	// origins maps "<kind>.<name>" to the file defining it.
+	origins map[string]string
+	// files are the config files read by Load, in order.
+	files []string
+}
+
+// Resolve returns a local path relative to the config file directory,
+// the directory itself for an empty path.
+func (p Config) Resolve(path string) string {
+	if p.Dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(p.Dir, path)
 }
 
 type Server struct {
-	Alias        string `yaml:"alias"`
-	Comment      string `yaml:"comment"`
-	Use          string `yaml:"use"`
-	Host         string `yaml:"host"`
-	Port         int    `yaml:"port"`
-	User         string `yaml:"user"`
-	Password     string `yaml:"password"`
-	IdentityFile string `yaml:"identity_file"`
+	Alias        string `yaml:"alias,omitempty"`
+	Comment      string `yaml:"comment,omitempty"`
+	Use          string `yaml:"use,omitempty"`
+	Host         string `yaml:"host,omitempty"`
+	Port         int    `yaml:"port,omitempty"`
+	User         string `yaml:"user,omitempty"`
+	Password     string `yaml:"password,omitempty"`
+	IdentityFile string `yaml:"identity_file,omitempty"`
+	// SSHConfig is a host alias of ~/.ssh/config, resolving the host, port,
+	// user, identity file, ProxyJump and ProxyCommand not set here.
+	SSHConfig string `yaml:"ssh_config,omitempty"`
+	// Jump is the name of a server to connect through, e.g. a bastion,
+	// which may have a jump of its own.
+	Jump string `yaml:"jump,omitempty"`
+	// Proxy is the URL of a proxy to connect through, see ProxySchemes.
+	Proxy string `yaml:"proxy,omitempty"`
+	// Envs are exported to remote executes on this server.
+	Envs map[string]string `yaml:"envs,omitempty"`
+	// HostKeyPolicy is strict, tofu (default) or off, see HostKeyPolicies.
+	HostKeyPolicy string `yaml:"host_key_policy,omitempty"`
+	// HostKey pins the host key fingerprint, e.g. SHA256:..., as printed
+	// by `cast server trust` or `ssh-keygen -lf`.
+	HostKey string `yaml:"host_key,omitempty"`
+	// Auth lists the authentication methods to try in order, see
+	// AuthMethods; by default agent, publickey, password and
+	// keyboard-interactive.
+	Auth []string `yaml:"auth,omitempty"`
+	// CertificateFile is the certificate of IdentityFile, by default
+	// <identity_file>-cert.pub if it exists.
+	CertificateFile string `yaml:"certificate_file,omitempty"`
+	// Connect tunes the connection to the server, which a run opens once
+	// and shares between uploads and executes.
+	Connect *Connect `yaml:"connect,omitempty"`
+}
+
+// Connect is the connect timeout, retry and keepalive policy of a server.
+type Connect struct {
+	// Timeout bounds each step of reaching the server, the TCP connection,
+	// jump or proxy, then the SSH handshake, 60s by default.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Retries is how many times reaching the server is retried, waiting
+	// RetryWait (2s by default) first, then twice as long on each retry.
+	Retries   int           `yaml:"retries,omitempty"`
+	RetryWait time.Duration `yaml:"retry_wait,omitempty"`
+	// KeepAlive is the interval of keepalive requests, 30s by default,
+	// none if negative. The connection is dropped after KeepAliveMax
+	// (3 by default) unanswered ones, and dialed again on next use.
+	KeepAlive    time.Duration `yaml:"keep_alive,omitempty"`
+	KeepAliveMax int           `yaml:"keep_alive_max,omitempty"`
 }
 
+// HostKeyPolicies are the values of Server.HostKeyPolicy: strict accepts
+// only known host keys, tofu trusts a host seen for the first time and
+// off doesn't check.
+var HostKeyPolicies = []string{"strict", "tofu", "off"}
+
+// AuthMethods are the values of Server.Auth.
+var AuthMethods = []string{"agent", "publickey", "password", "keyboard-interactive"}
+
+// ProxySchemes are the schemes of Server.Proxy: socks5 resolves the server
+// name locally, socks5h on the proxy, and http tunnels with CONNECT.
+var ProxySchemes = []string{"socks5", "socks5h", "http"}
+
 func (p Server) Name() string {
 	serverName := p.Host
+	if serverName == "" {
+		serverName = p.SSHConfig
+	}
 	if p.Comment != "" {
 		serverName = fmt.Sprintf("%s:%s", p.Comment, serverName)
 	}
@@ -37,7 +123,24 @@ type Task struct {
 	Workspace string            `yaml:"workspace"`
 	Branches  []string          `yaml:"branches"`
 	Envs      map[string]string `yaml:"envs"`
+	LocalEnvs []string          `yaml:"local_envs"`
+	Params    []*Param          `yaml:"params"`
 	Steps     []*Step           `yaml:"steps"`
+	// RequireClean refuses to run with uncommitted changes in the workspace,
+	// RequireTag unless its HEAD is tagged. Branches entries may be globs.
+	RequireClean bool `yaml:"require_clean"`
+	RequireTag   bool `yaml:"require_tag"`
+}
+
+// Param declares an input of a task, set with `--set name=value` or
+// `cast run task:name=value`, and exposed as an env and a ${name} variable.
+type Param struct {
+	Name     string `yaml:"name"`
+	Comment  string `yaml:"comment"`
+	Default  string `yaml:"default"`
+	Required bool   `yaml:"required"`
+	// Values lists the allowed values, any value when empty.
+	Values []string `yaml:"values"`
 }
 
 type Step struct {
@@ -50,16 +153,98 @@ type Step struct {
 type Execute struct {
 	Comment string `yaml:"comment"`
 	Use     string `yaml:"use"`
-	Run     string `yaml:"run"`
+	// Run only expands the variables of the config and --set, the remote
+	// shell expands the others.
+	Run string `yaml:"run" expand:"shell"`
+	// Envs are exported to this execute, after the task and server envs.
+	Envs map[string]string `yaml:"envs"`
+	// Workdir is the remote directory the command runs in.
+	Workdir string `yaml:"workdir"`
+	// Shell runs the command with `<shell> -c`, e.g. bash or /bin/zsh,
+	// instead of the login shell of the user.
+	Shell string `yaml:"shell"`
+	// Sudo runs the command as root, or as SudoUser if set.
+	Sudo     bool   `yaml:"sudo"`
+	SudoUser string `yaml:"sudo_user"`
 }
 
 type Deploy struct {
+	// Parallel is the max number of servers deployed concurrently,
+	// values <= 1 keep the sequential behaviour.
+	Parallel int        `yaml:"parallel"`
+	Strategy *Strategy  `yaml:"strategy"`
 	Servers  []*Server  `yaml:"servers"`
 	Mappers  []*Mapper  `yaml:"mappers"`
 	Executes []*Execute `yaml:"executes"`
 }
 
+// Strategy deploys servers in waves of BatchSize, stopping before the next
+// wave once more than MaxFailures servers failed.
+type Strategy struct {
+	BatchSize   BatchSize     `yaml:"batch_size"`
+	MaxFailures int           `yaml:"max_failures"`
+	Pause       time.Duration `yaml:"pause"`
+}
+
+// BatchSize is a number of servers, e.g. 2, or a percentage of
+// them, e.g. 25%. Zero means all servers at once.
+type BatchSize struct {
+	Count   int
+	Percent int
+}
+
+func (p *BatchSize) UnmarshalYAML(node *yaml.Node) error {
+	value := strings.TrimSpace(node.Value)
+	var err error
+	if strings.HasSuffix(value, "%") {
+		p.Count = 0
+		p.Percent, err = strconv.Atoi(strings.TrimSuffix(value, "%"))
+	} else {
+		p.Percent = 0
+		p.Count, err = strconv.Atoi(value)
+	}
+	if node.Kind != yaml.ScalarNode || err != nil {
+		return &yaml.TypeError{Errors: []string{
+			fmt.Sprintf("line %d: batch_size must be a number or a percentage, e.g. 25%%, got: %s", node.Line, node.Value),
+		}}
+	}
+	return nil
+}
+
+// Of returns the batch size for total servers. A percentage rounds
+// up, so that each wave has at least one server.
+func (p BatchSize) Of(total int) int {
+	if p.Percent > 0 {
+		return (total*p.Percent + 99) / 100
+	}
+	return p.Count
+}
+
+func (p BatchSize) String() string {
+	if p.Percent > 0 {
+		return fmt.Sprintf("%d%%", p.Percent)
+	}
+	return strconv.Itoa(p.Count)
+}
+
+const (
+	// MapperModeSync uploads only the files of a directory that changed
+	// since the last sync.
+	MapperModeSync = "sync"
+	// MapperLayoutReleases extracts every deploy into its own
+	// releases/<time> directory and switches a `current` symlink to it.
+	MapperLayoutReleases = "releases"
+)
+
 type Mapper struct {
 	Source string `yaml:"source"`
 	Target string `yaml:"target"`
+	Mode   string `yaml:"mode"`
+	// Delete removes remote files that no longer exist locally, sync mode only.
+	Delete bool `yaml:"delete"`
+	// Layout: releases deploys into releases/<time> behind a `current` symlink,
+	// keeping the newest Keep releases (default 5). Without it, Keep is the
+	// number of deploys whose replaced files are kept for rollbacks.
+	Layout string `yaml:"layout"`
+	Keep   int    `yaml:"keep"`
 }
@@ -0,0 +1,48 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	infra "github.com/koyeo/cast/deploy/infrastructure"
+	"golang.org/x/term"
+)
+
+var (
+	keyringOnce sync.Once
+	sshKeyring  *infra.Keyring
+)
+
+// keyring returns the keys of a run, so that the passphrase of a key
+// is asked once for all servers.
+func keyring() *infra.Keyring {
+	keyringOnce.Do(func() {
+		sshKeyring = infra.NewKeyring(terminalPrompt)
+	})
+	return sshKeyring
+}
+
+// terminalPrompt asks for a passphrase or a keyboard-interactive answer
+// on the terminal, holding the output of concurrent servers meanwhile.
+func terminalPrompt(message string, echo bool) (string, error) {
+	stdoutLock.Lock()
+	defer stdoutLock.Unlock()
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("can't ask %q, stdin is not a terminal", strings.TrimSpace(message))
+	}
+	_, _ = fmt.Fprint(os.Stderr, message)
+	if echo {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	answer, err := term.ReadPassword(fd)
+	_, _ = fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("read answer error: %s", err)
+	}
+	return string(answer), nil
+}
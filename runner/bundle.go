@@ -0,0 +1,149 @@
+package runner
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"github.com/koyeo/cast/utils/_tar"
+	"github.com/koyeo/cast/utils/unit"
+	"io"
+	"os"
+	"sync"
+)
+
+// bundle is the tar.gz of a mapper source. Its hash must be known before
+// any upload for the unchanged check, so it is compressed once per run
+// into a temp file, shared by every mapper and server deploying the same
+// source and copied from there into SFTP.
+type bundle struct {
+	source string
+	name   string
+	hash   string
+	size   int64
+	file   string
+	once   sync.Once
+	err    error
+}
+
+// compress writes the source into a temp file, learning the hash needed
+// for the unchanged check and the size for progress.
+func (b *bundle) compress() {
+	f, err := os.CreateTemp("", "cast-bundle-*.tar.gz")
+	if err != nil {
+		b.err = fmt.Errorf("create bundle file error: %s", err)
+		return
+	}
+	h := sha256.New()
+	counter := &countWriter{}
+	buffered := bufio.NewWriterSize(io.MultiWriter(f, h, counter), uploadBufferSize)
+	err = _tar.Write(buffered, b.source)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		b.err = fmt.Errorf("compress source error: %s", err)
+		return
+	}
+	b.file = f.Name()
+	b.hash = fmt.Sprintf("%x", h.Sum(nil))
+	b.size = counter.n
+}
+
+// writeTo copies the compressed bundle into w.
+func (b *bundle) writeTo(w io.Writer) error {
+	f, err := os.Open(b.file)
+	if err != nil {
+		return fmt.Errorf("open bundle file error: %s", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if _, err = io.Copy(w, f); err != nil {
+		return fmt.Errorf("write bundle error: %s", err)
+	}
+	return nil
+}
+
+// BundleCache shares bundles between the tasks and servers of a run.
+type BundleCache struct {
+	mu      sync.Mutex
+	bundles map[string]*bundle
+}
+
+func NewBundleCache() *BundleCache {
+	return &BundleCache{bundles: map[string]*bundle{}}
+}
+
+func (c *BundleCache) get(source string) (*bundle, error) {
+	c.mu.Lock()
+	b, ok := c.bundles[source]
+	if !ok {
+		b = &bundle{source: source, name: bundleNameOf(source)}
+		c.bundles[source] = b
+	}
+	c.mu.Unlock()
+	b.once.Do(b.compress)
+	return b, b.err
+}
+
+// Remove deletes the temp files of the bundles.
+func (c *BundleCache) Remove() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for source, b := range c.bundles {
+		if b.file != "" {
+			_ = os.Remove(b.file)
+		}
+		delete(c.bundles, source)
+	}
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// progressWriter reports uploaded bytes after each write, in place when
+// attached to the terminal, otherwise once at the end.
+type progressWriter struct {
+	w        io.Writer
+	out      io.Writer
+	inplace  bool
+	total    int64
+	uploaded int64
+}
+
+func (p *progressWriter) Write(data []byte) (int, error) {
+	n, err := p.w.Write(data)
+	p.uploaded += int64(n)
+	if p.inplace {
+		fmt.Print("\r" + p.String())
+	}
+	return n, err
+}
+
+func (p *progressWriter) Done() {
+	if p.inplace {
+		fmt.Printf("\n")
+		return
+	}
+	_, _ = fmt.Fprintln(p.out, p.String())
+}
+
+func (p *progressWriter) String() string {
+	if p.total < 0 {
+		return fmt.Sprintf("Uploaded: %s", unit.ByteSize(p.uploaded))
+	}
+	return fmt.Sprintf("Total: %s Uploaded: %s", unit.ByteSize(p.total), unit.ByteSize(p.uploaded))
+}
+
+// uploadBufferSize batches compressed output into SFTP writes.
+const uploadBufferSize = 1024 * 1024
@@ -0,0 +1,67 @@
+package runner
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBundleCache(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "dist")
+	if err := os.MkdirAll(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewBundleCache()
+	b, err := cache.get(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.name != "dist.tar.gz" {
+		t.Errorf("expected name dist.tar.gz, got %s", b.name)
+	}
+
+	// later mappers and servers reuse the compressed file, even if the
+	// source changes meanwhile
+	if err = os.WriteFile(filepath.Join(source, "a.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	again, err := cache.get(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != b {
+		t.Fatal("expected the bundle shared")
+	}
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		if err = b.writeTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if int64(buf.Len()) != b.size {
+			t.Errorf("expected %d bytes, got %d", b.size, buf.Len())
+		}
+		if hash := fmt.Sprintf("%x", sha256.Sum256(buf.Bytes())); hash != b.hash {
+			t.Errorf("expected hash %s, got %s", b.hash, hash)
+		}
+	}
+
+	cache.Remove()
+	if _, err = os.Stat(b.file); !os.IsNotExist(err) {
+		t.Errorf("expected the bundle file removed, got %v", err)
+	}
+}
+
+func TestBundleCache_MissingSource(t *testing.T) {
+	cache := NewBundleCache()
+	_, err := cache.get(filepath.Join(t.TempDir(), "missing"))
+	if err == nil {
+		t.Fatal("expected compress error")
+	}
+	cache.Remove()
+}
@@ -0,0 +1,112 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	infra "github.com/koyeo/cast/deploy/infrastructure"
+	"github.com/koyeo/cast/protocol"
+)
+
+// Connect defaults, see protocol.Connect.
+const (
+	defaultConnectTimeout = 60 * time.Second
+	defaultRetryWait      = 2 * time.Second
+	defaultKeepAlive      = 30 * time.Second
+	defaultKeepAliveMax   = 3
+)
+
+// sharedConn is the connection of a server, dialed once per run.
+type sharedConn struct {
+	once sync.Once
+	conn *infra.SSHConn
+	err  error
+}
+
+var (
+	connsMu sync.Mutex
+	conns   = map[string]*sharedConn{}
+)
+
+// connKey identifies the connection of a resolved server: servers of
+// several tasks or deploys share it only when they reach the same account
+// the same way, with the same host key check, credentials and connect
+// settings. The password only enters the key as a hash.
+func connKey(server *protocol.Server) string {
+	port := server.Port
+	if port == 0 {
+		port = 22
+	}
+	connect := protocol.Connect{}
+	if server.Connect != nil {
+		connect = *server.Connect
+	}
+	return fmt.Sprintf("%s@%s:%d ssh_config=%q jump=%q proxy=%q host_key_policy=%q host_key=%q "+
+		"auth=%q password=%q identity_file=%q certificate_file=%q connect=%+v",
+		server.User, server.Host, port, server.SSHConfig, server.Jump, server.Proxy, server.HostKeyPolicy, server.HostKey,
+		server.Auth, fmt.Sprintf("%x", sha256.Sum256([]byte(server.Password))), server.IdentityFile, server.CertificateFile, connect)
+}
+
+// sharedConnOf returns the connection of key, dialing it with dial
+// on first use. A failed dial is forgotten, so that it can be tried again.
+func sharedConnOf(key string, dial func() (*infra.SSHConn, error)) (*infra.SSHConn, error) {
+	connsMu.Lock()
+	entry, ok := conns[key]
+	if !ok {
+		entry = &sharedConn{}
+		conns[key] = entry
+	}
+	connsMu.Unlock()
+	entry.once.Do(func() {
+		entry.conn, entry.err = dial()
+	})
+	if entry.err != nil {
+		connsMu.Lock()
+		if conns[key] == entry {
+			delete(conns, key)
+		}
+		connsMu.Unlock()
+		return nil, entry.err
+	}
+	return entry.conn, nil
+}
+
+// CloseConnections closes the server connections opened by the run,
+// and its ssh-agent connection.
+func CloseConnections() {
+	connsMu.Lock()
+	defer connsMu.Unlock()
+	for key, entry := range conns {
+		if entry.conn != nil {
+			entry.conn.Close()
+		}
+		delete(conns, key)
+	}
+	keyring().Close()
+}
+
+// connectOptions sets the timeout, retry and keepalive policy of a server.
+func connectOptions(opts *infra.SSHOptions, connect *protocol.Connect) {
+	if connect == nil {
+		connect = &protocol.Connect{}
+	}
+	opts.Timeout = connect.Timeout
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultConnectTimeout
+	}
+	opts.Retries = connect.Retries
+	opts.RetryWait = connect.RetryWait
+	if opts.RetryWait == 0 {
+		opts.RetryWait = defaultRetryWait
+	}
+	opts.KeepAlive = connect.KeepAlive
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = defaultKeepAlive
+	}
+	opts.KeepAliveMax = connect.KeepAliveMax
+	if opts.KeepAliveMax == 0 {
+		opts.KeepAliveMax = defaultKeepAliveMax
+	}
+}
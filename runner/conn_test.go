@@ -0,0 +1,48 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koyeo/cast/protocol"
+)
+
+func TestConnKey(t *testing.T) {
+	base := func() *protocol.Server {
+		return &protocol.Server{Host: "10.0.0.1", User: "deploy", Password: "pw"}
+	}
+	key := connKey(base())
+	same := base()
+	same.Port = 22
+	same.Comment = "renamed"
+	same.Envs = map[string]string{"A": "1"}
+	same.Connect = &protocol.Connect{}
+	if got := connKey(same); got != key {
+		t.Errorf("expected the connection shared, got keys:\n%s\n%s", key, got)
+	}
+	if strings.Contains(key, "pw") {
+		t.Errorf("expected no password in the key, got %s", key)
+	}
+
+	for name, change := range map[string]func(s *protocol.Server){
+		"port":             func(s *protocol.Server) { s.Port = 2222 },
+		"user":             func(s *protocol.Server) { s.User = "root" },
+		"ssh_config":       func(s *protocol.Server) { s.SSHConfig = "web" },
+		"jump":             func(s *protocol.Server) { s.Jump = "bastion" },
+		"proxy":            func(s *protocol.Server) { s.Proxy = "socks5://10.0.0.2:1080" },
+		"host_key_policy":  func(s *protocol.Server) { s.HostKeyPolicy = "strict" },
+		"host_key":         func(s *protocol.Server) { s.HostKey = "SHA256:abc" },
+		"auth":             func(s *protocol.Server) { s.Auth = []string{"password"} },
+		"password":         func(s *protocol.Server) { s.Password = "other" },
+		"identity_file":    func(s *protocol.Server) { s.IdentityFile = "~/.ssh/deploy" },
+		"certificate_file": func(s *protocol.Server) { s.CertificateFile = "~/.ssh/deploy-cert.pub" },
+		"connect":          func(s *protocol.Server) { s.Connect = &protocol.Connect{Timeout: time.Second} },
+	} {
+		server := base()
+		change(server)
+		if connKey(server) == key {
+			t.Errorf("%s: expected a connection of its own", name)
+		}
+	}
+}
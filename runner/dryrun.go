@@ -0,0 +1,159 @@
+package runner
+
+import (
+	"fmt"
+	"github.com/gozelle/_color"
+	"github.com/koyeo/cast/config"
+	application "github.com/koyeo/cast/deploy/application"
+	infra "github.com/koyeo/cast/deploy/infrastructure"
+	"github.com/koyeo/cast/logger"
+	"github.com/koyeo/cast/protocol"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// planEnvs prints the names of the envs local commands of the task would
+// get, leaving out their values.
+func (p TaskRunner) planEnvs() {
+	environ := p.prepareEnviron()
+	if len(environ) == 0 {
+		return
+	}
+	names := make([]string, 0, len(environ))
+	for _, v := range environ {
+		names = append(names, strings.SplitN(v, "=", 2)[0])
+	}
+	sort.Strings(names)
+	logger.Step(p.key, p.task.Comment, "🌱", _color.New(_color.FgWhite).Sprintf("export %s", strings.Join(names, " ")))
+}
+
+// planDeploy prints what a deploy would upload and execute on every server,
+// connecting read-only to show the files it would conflict with.
+func (p *TaskRunner) planDeploy(deploy *protocol.Deploy) (err error) {
+	keys, servers, err := p.resolveServers(deploy.Servers, p.lookupFor(p.task, p.params))
+	if err != nil {
+		return
+	}
+	if deploy.Strategy != nil {
+		logger.Step(p.key, p.task.Comment, "🌊", fmt.Sprintf("rolling: %d waves, max_failures: %d, pause: %s",
+			len(splitBatches(keys, deploy.Strategy.BatchSize.Of(len(keys)))), deploy.Strategy.MaxFailures, deploy.Strategy.Pause))
+	} else if deploy.Parallel > 1 {
+		logger.Step(p.key, p.task.Comment, "⚡", fmt.Sprintf("parallel: %d", deploy.Parallel))
+	}
+	for _, key := range keys {
+		server := servers[key]
+		serverRunner := NewServerRunner(p.conf, p, server, key)
+		for _, mapper := range deploy.Mappers {
+			serverRunner.planMapper(mapper)
+		}
+		for _, execute := range deploy.Executes {
+			if execute.Run != "" {
+				p.printServerExec(server, describeExecute(execute))
+				p.planRemoteEnvs(server, execute)
+			}
+		}
+	}
+	return
+}
+
+// planRemoteEnvs prints the names of the envs exported to a remote execute,
+// leaving out their values.
+func (p TaskRunner) planRemoteEnvs(server *protocol.Server, execute *protocol.Execute) {
+	envs := p.remoteEnvs(server, execute)
+	if len(envs) == 0 {
+		return
+	}
+	names := make([]string, 0, len(envs))
+	for k := range envs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	logger.Step(p.key, p.task.Comment, "🌱",
+		_color.New(_color.FgCyan).Sprintf("[%s]", server.Name()),
+		_color.New(_color.FgWhite).Sprintf("export %s", strings.Join(names, " ")))
+}
+
+// planMapper prints the upload of a mapper as Upload would resolve it, then
+// the conflicts it would meet on the server if the server is reachable.
+func (p *ServerRunner) planMapper(mapper *protocol.Mapper) {
+	source, target := mapper.Source, mapper.Target
+	targetDir := resolveTargetDir(target)
+	sourceName := path.Base(source)
+	targetName := sourceName
+	if !strings.HasSuffix(target, "/") {
+		targetName = path.Base(target)
+	}
+	p.printUpload(source, targetDir, targetName)
+
+	if err := p.checkTargetPath(target); err != nil {
+		p.printPlan(_color.New(_color.FgHiRed).Sprint(err))
+		return
+	}
+	if _, err := os.Stat(p.conf.Resolve(source)); err != nil {
+		p.printPlan(_color.New(_color.FgHiRed).Sprintf("upload source: %s not exists", source))
+		return
+	}
+	if mapper.Mode == protocol.MapperModeSync && mapper.Layout == protocol.MapperLayoutReleases {
+		p.printPlan(_color.New(_color.FgHiRed).Sprint("mode: sync can't be used with layout: releases"))
+		return
+	}
+
+	server, err := p.connect()
+	if err != nil {
+		p.printPlan(_color.New(_color.FgYellow).Sprintf("server unreachable, conflicts unknown: %s", err))
+		return
+	}
+	remoteFS, remoteExec, snapshotRepo := p.remote(server)
+	lang := config.Load().Lang
+
+	switch {
+	case mapper.Layout == protocol.MapperLayoutReleases:
+		p.printPlan(fmt.Sprintf("new release in %s, then switch current", filepath.Join(targetDir, "releases")))
+	case mapper.Mode == protocol.MapperModeSync:
+		local, hashErr := hashDir(p.conf.Resolve(source))
+		if hashErr != nil {
+			p.printPlan(_color.New(_color.FgHiRed).Sprint(hashErr))
+			return
+		}
+		syncSvc := application.NewSyncService(remoteFS, remoteExec, snapshotRepo, infra.NewManifestRepo(remoteFS), lang)
+		plan, manifest, planErr := syncSvc.Plan(targetDir, bundleNameOf(source), local, mapper.Delete)
+		if planErr != nil {
+			p.printPlan(_color.New(_color.FgHiRed).Sprint(planErr))
+			return
+		}
+		if manifest == nil {
+			p.printPlan("first sync, full upload")
+			return
+		}
+		p.printPlan(fmt.Sprintf("sync: %d files to upload, %d files to delete", len(plan.Upload), len(plan.Delete)))
+	default:
+		deploySvc := application.NewDeployService(remoteFS, remoteExec, snapshotRepo, nil, lang)
+		result, conflictErr := deploySvc.Conflicts(targetDir, []string{sourceName})
+		if conflictErr != nil {
+			p.printPlan(_color.New(_color.FgHiRed).Sprint(conflictErr))
+			return
+		}
+		for _, f := range result.ManagedFiles {
+			p.printPlan(fmt.Sprintf("%s: managed, replaced and kept in history", f))
+		}
+		for _, f := range result.UnmanagedFiles {
+			p.printPlan(_color.New(_color.FgYellow).Sprintf("%s: unmanaged, asks to backup or remove", f))
+		}
+		if len(result.ManagedFiles)+len(result.UnmanagedFiles) == 0 {
+			p.printPlan("no conflicts")
+		}
+	}
+}
+
+func (p *ServerRunner) printPlan(message string) {
+	logger.Step(
+		p.task.key,
+		p.task.task.Comment,
+		"🔍",
+		_color.New(_color.FgCyan).Sprintf("[%s]", p.server.Name()),
+		message,
+	)
+}
@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/koyeo/cast/common"
+	"github.com/koyeo/cast/protocol"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHOptions_DryRunTrustsNothing(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := filepath.Join(home, common.TmpWorkspace, "known_hosts")
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+	server := &protocol.Server{Host: "127.0.0.1", User: "deploy", Password: "pw"}
+
+	check := func(dryRun bool) error {
+		task := &TaskRunner{conf: &protocol.Config{}, task: &protocol.Task{}, opts: Options{DryRun: dryRun}}
+		opts, err := NewServerRunner(task.conf, task, server, "s1").sshOptions(server, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return opts.HostKeyCallback("127.0.0.1:22", addr, signer.PublicKey())
+	}
+	if err = check(true); err == nil {
+		t.Error("expected an unknown host key rejected by a dry run")
+	}
+	if _, err = os.Stat(store); !os.IsNotExist(err) {
+		t.Errorf("expected no known_hosts written by a dry run, got %v", err)
+	}
+	if err = check(false); err != nil {
+		t.Errorf("expected the host key trusted on first use, got %s", err)
+	}
+	if _, err = os.Stat(store); err != nil {
+		t.Errorf("expected known_hosts written, got %s", err)
+	}
+}
@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/koyeo/cast/protocol"
+)
+
+// remoteEnvs merges the envs of a remote execute: the global and task envs,
+// the server envs, the execute envs, then the values passed with --set and
+// the task params. Envs listed in local_envs are left out.
+func (p TaskRunner) remoteEnvs(server *protocol.Server, execute *protocol.Execute) map[string]string {
+	envs := map[string]string{}
+	for _, m := range []map[string]string{p.conf.Envs, p.task.Envs, server.Envs, execute.Envs, p.conf.Vars, p.params} {
+		for k, v := range m {
+			envs[k] = v
+		}
+	}
+	for _, names := range [][]string{p.conf.LocalEnvs, p.task.LocalEnvs} {
+		for _, name := range names {
+			delete(envs, name)
+		}
+	}
+	return envs
+}
+
+// withEnvs prefixes command with exports of envs, the values single-quoted
+// so the remote shell doesn't expand them.
+func withEnvs(command string, envs map[string]string) (string, error) {
+	if len(envs) == 0 {
+		return command, nil
+	}
+	names := make([]string, 0, len(envs))
+	for k := range envs {
+		if !protocol.ValidVarName(k) {
+			return "", fmt.Errorf("invalid env name: '%s'", k)
+		}
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	exports := make([]string, 0, len(names))
+	for _, k := range names {
+		exports = append(exports, fmt.Sprintf("%s=%s", k, shellQuote(envs[k])))
+	}
+	return fmt.Sprintf("export %s; %s", strings.Join(exports, " "), command), nil
+}
+
+// remoteCommand builds the command line of a remote execute: envs exported,
+// run in its workdir, under its shell and as its sudo user. stdin holds the
+// server password when sudo needs one.
+func (p TaskRunner) remoteCommand(server *protocol.Server, execute *protocol.Execute) (command string, stdin io.Reader, err error) {
+	command = execute.Run
+	if execute.Workdir != "" {
+		command = fmt.Sprintf("cd %s && %s", shellQuote(execute.Workdir), command)
+	}
+	command, err = withEnvs(command, p.remoteEnvs(server, execute))
+	if err != nil {
+		return
+	}
+	sudo := execute.Sudo || execute.SudoUser != ""
+	shell := execute.Shell
+	if shell == "" && sudo {
+		shell = "sh"
+	}
+	if shell != "" {
+		command = fmt.Sprintf("%s -c %s", shell, shellQuote(command))
+	}
+	if !sudo {
+		return
+	}
+	var user string
+	if execute.SudoUser != "" {
+		user = fmt.Sprintf(" -u %s", shellQuote(execute.SudoUser))
+	}
+	if server.Password == "" {
+		command = fmt.Sprintf("sudo -n%s -- %s", user, command)
+		return
+	}
+	// the password is read into the outer shell first and only piped to
+	// sudo if it asks for one, so that with NOPASSWD it never reaches the
+	// command's stdin; -k always asks, so the pipe is left empty
+	command = fmt.Sprintf(
+		`IFS= read -r password; if sudo -n%[1]s -- %[2]s -c : 2>/dev/null; then sudo -n%[1]s -- %[3]s; else printf '%%s\n' "$password" | sudo -k -S -p ''%[1]s -- %[3]s; fi`,
+		user, shell, command,
+	)
+	command = fmt.Sprintf("sh -c %s", shellQuote(command))
+	stdin = strings.NewReader(server.Password + "\n")
+	return
+}
+
+// describeExecute prints a remote execute with its workdir, shell and sudo
+// user, leaving out the envs as they may hold secrets.
+func describeExecute(execute *protocol.Execute) string {
+	var opts []string
+	if execute.SudoUser != "" {
+		opts = append(opts, "sudo -u "+execute.SudoUser)
+	} else if execute.Sudo {
+		opts = append(opts, "sudo")
+	}
+	if execute.Shell != "" {
+		opts = append(opts, execute.Shell)
+	}
+	if execute.Workdir != "" {
+		opts = append(opts, "in "+execute.Workdir)
+	}
+	if len(opts) == 0 {
+		return execute.Run
+	}
+	return fmt.Sprintf("(%s) %s", strings.Join(opts, ", "), execute.Run)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
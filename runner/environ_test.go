@@ -0,0 +1,189 @@
+package runner
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/koyeo/cast/protocol"
+)
+
+func TestRemoteEnvs(t *testing.T) {
+	p := TaskRunner{
+		conf: &protocol.Config{
+			Envs:      map[string]string{"GLOBAL": "global", "TASK": "global", "SERVER": "global", "EXECUTE": "global", "SET": "global", "PARAM": "global", "TOKEN": "secret"},
+			Vars:      map[string]string{"SET": "set", "PARAM": "set"},
+			LocalEnvs: []string{"TOKEN"},
+		},
+		task: &protocol.Task{
+			Envs:      map[string]string{"TASK": "task", "SERVER": "task", "EXECUTE": "task", "SET": "task", "PARAM": "task", "KEY": "key"},
+			LocalEnvs: []string{"KEY"},
+		},
+		params: map[string]string{"PARAM": "param"},
+	}
+	server := &protocol.Server{Envs: map[string]string{"SERVER": "server", "EXECUTE": "server", "SET": "server", "PARAM": "server"}}
+	execute := &protocol.Execute{Envs: map[string]string{"EXECUTE": "execute", "SET": "execute", "PARAM": "execute"}}
+
+	want := map[string]string{
+		"GLOBAL":  "global",
+		"TASK":    "task",
+		"SERVER":  "server",
+		"EXECUTE": "execute",
+		"SET":     "set",
+		"PARAM":   "param",
+	}
+	if got := p.remoteEnvs(server, execute); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestWithEnvs(t *testing.T) {
+	envs := map[string]string{
+		"QUOTE":   "it's",
+		"DOLLAR":  "$HOME `id` $(id)",
+		"NEWLINE": "a\nb",
+	}
+	command, err := withEnvs(`printf '%s|%s|%s' "$QUOTE" "$DOLLAR" "$NEWLINE"`, envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("sh", "-c", command).Output()
+	if err != nil {
+		t.Fatalf("run %s error: %s", command, err)
+	}
+	if want := "it's|$HOME `id` $(id)|a\nb"; string(out) != want {
+		t.Errorf("expected %q, got %q", want, out)
+	}
+
+	if command, err = withEnvs("true", nil); err != nil || command != "true" {
+		t.Errorf("expected the command unchanged, got %q %v", command, err)
+	}
+}
+
+func TestWithEnvs_InvalidName(t *testing.T) {
+	for _, name := range []string{"A-B", "1A", "A B", "A;rm", ""} {
+		if _, err := withEnvs("true", map[string]string{name: "x"}); err == nil {
+			t.Errorf("expected env name %q rejected", name)
+		}
+	}
+}
+
+func TestRemoteCommand(t *testing.T) {
+	p := TaskRunner{conf: &protocol.Config{}, task: &protocol.Task{}}
+	for _, c := range []struct {
+		name     string
+		password string
+		execute  *protocol.Execute
+		command  string
+		describe string
+	}{
+		{
+			name:     "plain",
+			execute:  &protocol.Execute{Run: "ls"},
+			command:  "ls",
+			describe: "ls",
+		},
+		{
+			name:     "workdir",
+			execute:  &protocol.Execute{Run: "ls", Workdir: "/srv/it's"},
+			command:  `cd '/srv/it'\''s' && ls`,
+			describe: "(in /srv/it's) ls",
+		},
+		{
+			name:     "envs",
+			execute:  &protocol.Execute{Run: "ls", Envs: map[string]string{"A": "1"}},
+			command:  "export A='1'; ls",
+			describe: "ls",
+		},
+		{
+			name:     "shell",
+			execute:  &protocol.Execute{Run: "echo 'x'", Shell: "bash"},
+			command:  `bash -c 'echo '\''x'\'''`,
+			describe: "(bash) echo 'x'",
+		},
+		{
+			name:     "sudo",
+			execute:  &protocol.Execute{Run: "ls", Sudo: true, Workdir: "/srv"},
+			command:  `sudo -n -- sh -c 'cd '\''/srv'\'' && ls'`,
+			describe: "(sudo, in /srv) ls",
+		},
+		{
+			name:     "sudo_user",
+			execute:  &protocol.Execute{Run: "ls", SudoUser: "app", Shell: "bash"},
+			command:  `sudo -n -u 'app' -- bash -c 'ls'`,
+			describe: "(sudo -u app, bash) ls",
+		},
+		{
+			name:     "sudo_password",
+			password: "pw",
+			execute:  &protocol.Execute{Run: "ls", SudoUser: "app"},
+			command: `sh -c 'IFS= read -r password; if sudo -n -u '\''app'\'' -- sh -c : 2>/dev/null; ` +
+				`then sudo -n -u '\''app'\'' -- sh -c '\''ls'\''; ` +
+				`else printf '\''%s\n'\'' "$password" | sudo -k -S -p '\'''\'' -u '\''app'\'' -- sh -c '\''ls'\''; fi'`,
+			describe: "(sudo -u app) ls",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			command, stdin, err := p.remoteCommand(&protocol.Server{Password: c.password}, c.execute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if command != c.command {
+				t.Errorf("expected command:\n%s\ngot:\n%s", c.command, command)
+			}
+			if (stdin != nil) != (c.password != "") {
+				t.Errorf("expected stdin only with a sudo password, got %v", stdin)
+			}
+			if describe := describeExecute(c.execute); describe != c.describe {
+				t.Errorf("expected description %q, got %q", c.describe, describe)
+			}
+		})
+	}
+}
+
+// TestRemoteCommand_SudoPassword runs the command of a sudo execute with a
+// fake sudo, checking the password goes to sudo only when it asks for one.
+func TestRemoteCommand_SudoPassword(t *testing.T) {
+	bin := t.TempDir()
+	fake := `#!/bin/sh
+interactive=1
+while [ "$1" != "--" ]; do
+  case "$1" in -n) interactive=0;; -u|-p) shift;; esac
+  shift
+done
+shift
+if [ "$SUDO_MODE" = password ]; then
+  [ $interactive = 1 ] || exit 1
+  IFS= read -r pw; [ "$pw" = secret ] || { echo "wrong password" >&2; exit 1; }
+fi
+exec "$@"
+`
+	if err := os.WriteFile(filepath.Join(bin, "sudo"), []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p := TaskRunner{conf: &protocol.Config{}, task: &protocol.Task{}}
+	command, stdin, err := p.remoteCommand(&protocol.Server{Password: "secret"}, &protocol.Execute{Run: "echo stdin: $(cat)", Sudo: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, err := io.ReadAll(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mode := range []string{"nopasswd", "password"} {
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"), "SUDO_MODE="+mode)
+		cmd.Stdin = bytes.NewReader(input)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %s %s", mode, err, out)
+		}
+		if string(out) != "stdin:\n" {
+			t.Errorf("%s: expected an empty stdin for the command, got %q", mode, out)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package runner
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/koyeo/cast/protocol"
+)
+
+// checkGit enforces the branches, require_clean and require_tag of a task
+// against the git repository of its workspace.
+func checkGit(task *protocol.Task, workspace string) (err error) {
+	if len(task.Branches) == 0 && !task.RequireClean && !task.RequireTag {
+		return
+	}
+	if _, err = git(workspace, "rev-parse", "--git-dir"); err != nil {
+		return fmt.Errorf("workspace: %s is not a git repository", workspace)
+	}
+	if len(task.Branches) > 0 {
+		// fails on a detached HEAD, leaving branch empty
+		branch, _ := git(workspace, "symbolic-ref", "--short", "-q", "HEAD")
+		if branch == "" {
+			return fmt.Errorf("runs on branches: %s, HEAD is detached, pass --ignore-branch to override",
+				strings.Join(task.Branches, ", "))
+		}
+		if !matchBranch(task.Branches, branch) {
+			return fmt.Errorf("runs on branches: %s, not on: %s, pass --ignore-branch to override",
+				strings.Join(task.Branches, ", "), branch)
+		}
+	}
+	if task.RequireClean {
+		status, e := git(workspace, "status", "--porcelain")
+		if e != nil {
+			return e
+		}
+		if status != "" {
+			return fmt.Errorf("requires a clean working tree, commit or stash the changes first, or pass --ignore-branch to override")
+		}
+	}
+	if task.RequireTag {
+		tags, e := git(workspace, "tag", "--points-at", "HEAD")
+		if e != nil {
+			return e
+		}
+		if tags == "" {
+			return fmt.Errorf("requires a tagged commit, HEAD is not tagged, pass --ignore-branch to override")
+		}
+	}
+	return
+}
+
+// matchBranch reports whether branch matches one of the patterns,
+// globs like release/* included.
+func matchBranch(patterns []string, branch string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func git(dir string, args ...string) (string, error) {
+	c := exec.Command("git", args...)
+	c.Dir = dir
+	out, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s error: %s", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
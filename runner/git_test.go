@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/koyeo/cast/protocol"
+)
+
+func TestMatchBranch(t *testing.T) {
+	patterns := []string{"main", "release/*"}
+	for branch, want := range map[string]bool{
+		"main":            true,
+		"release/1.0":     true,
+		"release/1.0/fix": false,
+		"release":         false,
+		"dev":             false,
+		"main2":           false,
+	} {
+		if got := matchBranch(patterns, branch); got != want {
+			t.Errorf("branch %s: expected %v, got %v", branch, want, got)
+		}
+	}
+}
+
+// gitRepo creates a repository with one commit on branch main.
+func gitRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "checkout", "-q", "-b", "main")
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "add", "a.txt")
+	runGit(t, dir, "commit", "-q", "-m", "init")
+	return dir
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	c := exec.Command("git", append([]string{"-c", "user.name=cast", "-c", "user.email=cast@example.com"}, args...)...)
+	c.Dir = dir
+	if out, err := c.CombinedOutput(); err != nil {
+		t.Fatalf("git %s error: %s %s", strings.Join(args, " "), err, out)
+	}
+}
+
+func TestCheckGit(t *testing.T) {
+	dir := gitRepo(t)
+
+	expect := func(task *protocol.Task, want string) {
+		t.Helper()
+		err := checkGit(task, dir)
+		switch {
+		case want == "" && err != nil:
+			t.Errorf("expected no error, got %s", err)
+		case want != "" && (err == nil || !strings.Contains(err.Error(), want)):
+			t.Errorf("expected error containing %q, got %v", want, err)
+		}
+	}
+
+	expect(&protocol.Task{Branches: []string{"main"}}, "")
+	expect(&protocol.Task{Branches: []string{"release/*"}}, "not on: main")
+	runGit(t, dir, "checkout", "-q", "-b", "release/1.0")
+	expect(&protocol.Task{Branches: []string{"main", "release/*"}}, "")
+
+	// a dirty tree only matters with require_clean
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect(&protocol.Task{Branches: []string{"release/*"}}, "")
+	expect(&protocol.Task{RequireClean: true}, "requires a clean working tree")
+	runGit(t, dir, "checkout", "-q", "--", "a.txt")
+	expect(&protocol.Task{RequireClean: true}, "")
+
+	expect(&protocol.Task{RequireTag: true}, "HEAD is not tagged")
+	runGit(t, dir, "tag", "v1.0")
+	expect(&protocol.Task{RequireTag: true}, "")
+
+	runGit(t, dir, "checkout", "-q", "--detach")
+	expect(&protocol.Task{Branches: []string{"*"}}, "HEAD is detached")
+	expect(&protocol.Task{RequireClean: true, RequireTag: true}, "")
+}
+
+func TestCheckGit_NotRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	if err := checkGit(&protocol.Task{}, dir); err != nil {
+		t.Errorf("expected no check without restrictions, got %s", err)
+	}
+	err := checkGit(&protocol.Task{RequireClean: true}, dir)
+	if err == nil || !strings.Contains(err.Error(), "is not a git repository") {
+		t.Errorf("expected not a git repository, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/koyeo/cast/common"
+	infra "github.com/koyeo/cast/deploy/infrastructure"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	hostKeysOnce sync.Once
+	hostKeys     *infra.KnownHosts
+	hostKeysErr  error
+)
+
+// knownHosts returns the host keys of ~/.ssh/known_hosts and
+// ~/.cast/known_hosts, shared by the connections of a run.
+func knownHosts() (*infra.KnownHosts, error) {
+	hostKeysOnce.Do(func() {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			hostKeysErr = fmt.Errorf("get home dir error: %s", err)
+			return
+		}
+		hostKeys = infra.NewKnownHosts(
+			[]string{filepath.Join(home, ".ssh", "known_hosts")},
+			filepath.Join(home, common.TmpWorkspace, "known_hosts"),
+		)
+	})
+	return hostKeys, hostKeysErr
+}
+
+// HostKey returns the host key the server presents, without authenticating,
+// reaching it through its jump servers or proxy.
+func (p *ServerRunner) HostKey() (ssh.PublicKey, error) {
+	server, _, err := resolveSSHConfig(p.server)
+	if err != nil {
+		return nil, err
+	}
+	p.server = server
+	opts, err := p.sshOptions(server, 0)
+	if err != nil {
+		return nil, err
+	}
+	return infra.FetchHostKey(opts)
+}
+
+// TrustHostKey records key as the host key of the server in
+// ~/.cast/known_hosts, replacing the one recorded before.
+func (p *ServerRunner) TrustHostKey(key ssh.PublicKey) error {
+	server, _, err := resolveSSHConfig(p.server)
+	if err != nil {
+		return err
+	}
+	p.server = server
+	hostKeys, err := knownHosts()
+	if err != nil {
+		return err
+	}
+	return hostKeys.Trust(p.address(), key)
+}
+
+func (p *ServerRunner) address() string {
+	port := p.server.Port
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(p.server.Host, strconv.Itoa(port))
+}
@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/koyeo/cast/config"
+	application "github.com/koyeo/cast/deploy/application"
+	"github.com/koyeo/cast/deploy/domain"
+	infra "github.com/koyeo/cast/deploy/infrastructure"
+)
+
+// newLock describes this process as a lock holder.
+func newLock() *domain.Lock {
+	holder := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		holder = u.Username
+	}
+	host, _ := os.Hostname()
+	return &domain.Lock{
+		Holder:     holder,
+		Host:       host,
+		PID:        os.Getpid(),
+		AcquiredAt: time.Now().UTC(),
+	}
+}
+
+// withLock runs fn while holding the deploy lock of targetDir.
+func (p *ServerRunner) withLock(targetDir string, fn func() error) (err error) {
+	server, err := p.connect()
+	if err != nil {
+		return
+	}
+	lockSvc := application.NewLockService(infra.NewSSHRemoteFS(server), config.Load().Lang)
+	lockSvc.SetOutput(p.out)
+	lock := newLock()
+	if err = lockSvc.Acquire(targetDir, lock, p.task.opts.LockWait); err != nil {
+		return fmt.Errorf("[%s] %s", p.server.Name(), err)
+	}
+	defer func() {
+		if releaseErr := lockSvc.Release(targetDir, lock); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+	}()
+	return fn()
+}
+
+// Unlock removes the deploy lock of target when it is older than olderThan.
+// Returns the lock found (nil if none) and whether it was removed.
+func (p *ServerRunner) Unlock(target *Target, olderThan time.Duration) (*domain.Lock, bool, error) {
+	server, err := p.connect()
+	if err != nil {
+		return nil, false, err
+	}
+	return application.NewLockService(infra.NewSSHRemoteFS(server), config.Load().Lang).Unlock(target.Dir, olderThan)
+}
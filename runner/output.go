@@ -0,0 +1,57 @@
+package runner
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// stdoutLock serializes writes of prefixed lines from concurrent servers.
+var stdoutLock sync.Mutex
+
+// prefixWriter buffers output and writes it line by line, each line
+// prefixed, so that concurrent server output stays readable.
+type prefixWriter struct {
+	out    io.Writer
+	prefix string
+	buf    []byte
+	mu     sync.Mutex
+}
+
+func newPrefixWriter(out io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{out: out, prefix: prefix}
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := p.writeLine(p.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+	return len(data), nil
+}
+
+// Flush writes any remaining partial line.
+func (p *prefixWriter) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.buf) > 0 {
+		_ = p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	stdoutLock.Lock()
+	defer stdoutLock.Unlock()
+	_, err := p.out.Write(append([]byte(p.prefix), line...))
+	return err
+}
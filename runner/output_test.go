@@ -0,0 +1,28 @@
+package runner
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newPrefixWriter(&out, "[web] ")
+	for _, chunk := range []string{"fir", "st\nsec", "ond\n", "third"} {
+		n, err := w.Write([]byte(chunk))
+		if err != nil || n != len(chunk) {
+			t.Fatalf("write %q: %d %v", chunk, n, err)
+		}
+	}
+	if got, want := out.String(), "[web] first\n[web] second\n"; got != want {
+		t.Errorf("expected complete lines only before flush, got %q, want %q", got, want)
+	}
+	w.Flush()
+	if got, want := out.String(), "[web] first\n[web] second\n[web] third\n"; got != want {
+		t.Errorf("expected the partial line flushed, got %q, want %q", got, want)
+	}
+	w.Flush()
+	if got := out.String(); got != "[web] first\n[web] second\n[web] third\n" {
+		t.Errorf("expected a second flush to write nothing, got %q", got)
+	}
+}
@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"fmt"
+	"github.com/gozelle/_color"
+	"github.com/koyeo/cast/logger"
+	"github.com/koyeo/cast/protocol"
+	"time"
+)
+
+// deployRolling deploys servers in waves of strategy.BatchSize. Servers
+// within a wave run concurrently (bounded by deploy.Parallel when it is
+// larger than the batch). Once the failure count exceeds
+// strategy.MaxFailures, the remaining servers are left untouched.
+func (p *TaskRunner) deployRolling(deploy *protocol.Deploy, keys []string, servers map[string]*protocol.Server) (err error) {
+	strategy := deploy.Strategy
+	waves := splitBatches(keys, strategy.BatchSize.Of(len(keys)))
+	pause := func() {
+		if strategy.Pause > 0 {
+			p.printWavePause(strategy.Pause)
+			time.Sleep(strategy.Pause)
+		}
+	}
+	results, failed := rollWaves(waves, strategy.MaxFailures, pause, func(i int, wave []string) []error {
+		p.printWaveStart(i+1, len(waves), len(wave))
+		concurrency := len(wave)
+		if deploy.Parallel > 0 && deploy.Parallel < concurrency {
+			concurrency = deploy.Parallel
+		}
+		return p.deployServers(deploy, wave, servers, concurrency)
+	})
+
+	updated, skipped := 0, 0
+	for _, key := range keys {
+		if _, deployed := results[key]; !deployed {
+			skipped++
+			p.printServerSkipped(servers[key])
+			continue
+		}
+		if results[key] == nil {
+			updated++
+		}
+		p.printServerResult(servers[key], results[key])
+	}
+	logger.Step(p.key, p.task.Comment, "📋",
+		fmt.Sprintf("updated: %d, failed: %d, skipped: %d", updated, failed, skipped))
+	if failed > strategy.MaxFailures {
+		err = fmt.Errorf("rolling deploy stopped: %d failures exceed max_failures: %d", failed, strategy.MaxFailures)
+		return
+	}
+	return
+}
+
+// rollWaves deploys waves in order, calling pause between them, and stops
+// once more than maxFailures servers failed. It returns the result of
+// every deployed server, and the number of failures.
+func rollWaves(waves [][]string, maxFailures int, pause func(), deploy func(i int, wave []string) []error) (results map[string]error, failed int) {
+	results = map[string]error{}
+	for i, wave := range waves {
+		if i > 0 {
+			pause()
+		}
+		for j, err := range deploy(i, wave) {
+			results[wave[j]] = err
+			if err != nil {
+				failed++
+			}
+		}
+		if failed > maxFailures {
+			break
+		}
+	}
+	return
+}
+
+// splitBatches splits keys into consecutive batches of size,
+// a size <= 0 puts every key in a single batch.
+func splitBatches(keys []string, size int) [][]string {
+	if size <= 0 || size >= len(keys) {
+		if len(keys) == 0 {
+			return nil
+		}
+		return [][]string{keys}
+	}
+	batches := make([][]string, 0, (len(keys)+size-1)/size)
+	for i := 0; i < len(keys); i += size {
+		end := i + size
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batches = append(batches, keys[i:end])
+	}
+	return batches
+}
+
+func (p TaskRunner) printWaveStart(index, total, size int) {
+	logger.Step(p.key, p.task.Comment, "🌊",
+		_color.New(_color.FgHiBlue).Sprintf("wave %d/%d (%d servers)", index, total, size))
+}
+
+func (p TaskRunner) printWavePause(pause time.Duration) {
+	logger.Step(p.key, p.task.Comment, "⏸ ", fmt.Sprintf("pause %s", pause))
+}
@@ -0,0 +1,72 @@
+package runner
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/koyeo/cast/protocol"
+)
+
+func TestSplitBatches(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e"}
+	for _, c := range []struct {
+		size protocol.BatchSize
+		want [][]string
+	}{
+		{protocol.BatchSize{Count: 2}, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+		{protocol.BatchSize{Count: 1}, [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}}},
+		{protocol.BatchSize{Count: 5}, [][]string{keys}},
+		{protocol.BatchSize{Count: 10}, [][]string{keys}},
+		{protocol.BatchSize{}, [][]string{keys}},
+		// 40% of 5 servers is 2
+		{protocol.BatchSize{Percent: 40}, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+		// 30% of 5 servers is 1.5, rounded up to 2
+		{protocol.BatchSize{Percent: 30}, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+		// 1% still deploys one server per wave
+		{protocol.BatchSize{Percent: 1}, [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}}},
+		{protocol.BatchSize{Percent: 100}, [][]string{keys}},
+	} {
+		got := splitBatches(keys, c.size.Of(len(keys)))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("batch_size %s: got %v, want %v", c.size, got, c.want)
+		}
+	}
+	if got := splitBatches(nil, 2); got != nil {
+		t.Errorf("expected no batches without servers, got %v", got)
+	}
+}
+
+func TestRollWaves(t *testing.T) {
+	waves := [][]string{{"a", "b"}, {"c", "d"}, {"e", "f"}}
+	for _, c := range []struct {
+		name        string
+		failing     map[string]bool
+		maxFailures int
+		deployed    int
+		failed      int
+	}{
+		{"no failures", nil, 0, 6, 0},
+		{"failure at the threshold", map[string]bool{"a": true}, 1, 6, 1},
+		{"failure over the threshold", map[string]bool{"a": true}, 0, 2, 1},
+		{"failures adding up across waves", map[string]bool{"a": true, "c": true}, 1, 4, 2},
+		{"last wave over the threshold", map[string]bool{"f": true}, 0, 6, 1},
+	} {
+		pauses := 0
+		results, failed := rollWaves(waves, c.maxFailures, func() { pauses++ }, func(i int, wave []string) []error {
+			errs := make([]error, len(wave))
+			for j, key := range wave {
+				if c.failing[key] {
+					errs[j] = fmt.Errorf("%s failed", key)
+				}
+			}
+			return errs
+		})
+		if len(results) != c.deployed || failed != c.failed {
+			t.Errorf("%s: deployed %d, failed %d, want %d and %d", c.name, len(results), failed, c.deployed, c.failed)
+		}
+		if want := c.deployed/2 - 1; pauses != want {
+			t.Errorf("%s: paused %d times, want %d", c.name, pauses, want)
+		}
+	}
+}
@@ -1,22 +1,25 @@
 package runner
 
 import (
-	"crypto/sha256"
+	"bufio"
+	"errors"
 	"fmt"
 	"github.com/gozelle/_color"
-	"github.com/gozelle/_exec"
 	"github.com/gozelle/_fs"
 	"github.com/koyeo/cast/config"
 	application "github.com/koyeo/cast/deploy/application"
+	"github.com/koyeo/cast/deploy/domain"
 	infra "github.com/koyeo/cast/deploy/infrastructure"
 	"github.com/koyeo/cast/logger"
 	"github.com/koyeo/cast/protocol"
-	"github.com/koyeo/cast/utils/_tar"
-	"github.com/koyeo/cast/utils/unit"
+	"io"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func NewServerRunner(conf *protocol.Config, task *TaskRunner, server *protocol.Server, key string) *ServerRunner {
@@ -25,7 +28,7 @@ func NewServerRunner(conf *protocol.Config, task *TaskRunner, server *protocol.S
 		task:   task,
 		key:    key,
 		server: server,
-		pool:   _exec.NewServerPool(),
+		out:    os.Stdout,
 	}
 }
 
@@ -34,42 +37,182 @@ type ServerRunner struct {
 	conf   *protocol.Config
 	key    string
 	server *protocol.Server
-	pool   *_exec.ServerPool
+	conn   *infra.SSHConn
+	out    io.Writer
 }
 
-func (p *ServerRunner) Close() {
-	if p.pool != nil {
-		p.pool.Close()
+// SetOutput redirects remote command output and deploy messages,
+// used to prefix lines when deploying to servers concurrently.
+func (p *ServerRunner) SetOutput(out io.Writer) {
+	p.out = out
+}
+
+// interactive reports whether output goes straight to the terminal,
+// in which case in-place progress can be printed.
+func (p *ServerRunner) interactive() bool {
+	return p.out == os.Stdout
+}
+
+// connect returns the connection to the server, shared by the runners
+// of the run and dialed on first use, or again if it dropped.
+func (p *ServerRunner) connect() (*infra.SSHConn, error) {
+	if p.conn == nil {
+		server, _, err := resolveSSHConfig(p.server)
+		if err != nil {
+			return nil, err
+		}
+		p.server = server
+		conn, err := sharedConnOf(connKey(p.server), func() (*infra.SSHConn, error) {
+			opts, err := p.sshOptions(p.server, 0)
+			if err != nil {
+				return nil, err
+			}
+			name := p.server.Name()
+			opts.OnRetry = func(err error, wait time.Duration) {
+				_, _ = fmt.Fprintf(p.out, "connect %s error: %s, retry in %s\n", name, err, wait)
+			}
+			return infra.DialSSH(opts)
+		})
+		if err != nil {
+			return nil, err
+		}
+		p.conn = conn
+	}
+	reconnected, err := p.conn.Ensure()
+	if err != nil {
+		return nil, err
+	}
+	if reconnected {
+		_, _ = fmt.Fprintf(p.out, "connection to %s dropped, reconnected\n", p.server.Name())
 	}
+	return p.conn, nil
 }
 
-func (p *ServerRunner) newExecServer() (*_exec.Server, error) {
-	if p.pool == nil {
-		return nil, fmt.Errorf("pool is nil")
+// sshOptions returns how to connect and authenticate to a server, or to
+// one of its jump servers, hops away from the target.
+func (p *ServerRunner) sshOptions(server *protocol.Server, hops int) (opts infra.SSHOptions, err error) {
+	if hops > maxJumps {
+		err = fmt.Errorf("more than %d jump servers, do the jumps loop?", maxJumps)
+		return
 	}
-	if p.server.Port == 0 {
-		p.server.Port = 22
+	server, host, err := resolveSSHConfig(server)
+	if err != nil {
+		return
 	}
-	if p.server.Password == "" && p.server.IdentityFile == "" {
-		p.server.IdentityFile = "~/.ssh/id_rsa"
+	if server.Port == 0 {
+		server.Port = 22
 	}
-	server := p.pool.New(&_exec.Server{
-		Key:          p.key,
-		Host:         p.server.Host,
-		Port:         p.server.Port,
-		User:         p.server.User,
-		Password:     p.server.Password,
-		IdentityFile: p.server.IdentityFile,
+	auth, err := keyring().AuthMethods(infra.SSHAuth{
+		Name:            server.Name(),
+		Methods:         server.Auth,
+		Password:        server.Password,
+		IdentityFile:    server.IdentityFile,
+		CertificateFile: server.CertificateFile,
 	})
-	err := server.InitSFTP()
 	if err != nil {
-		return nil, err
+		return
+	}
+	hostKeys, err := knownHosts()
+	if err != nil {
+		return
+	}
+	policy := server.HostKeyPolicy
+	// a dry run checks host keys without trusting new ones
+	if p.task.opts.DryRun && (policy == "" || policy == infra.HostKeyTOFU) {
+		policy = infra.HostKeyStrict
+	}
+	hostKeyCallback, err := hostKeys.Callback(policy, server.HostKey, p.out)
+	if err != nil {
+		return
+	}
+	opts = infra.SSHOptions{
+		Host:            server.Host,
+		Port:            server.Port,
+		User:            server.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	}
+	if server.HostKey == "" && server.HostKeyPolicy != infra.HostKeyOff {
+		opts.HostKeyAlgorithms, err = hostKeys.HostKeyAlgorithms(net.JoinHostPort(server.Host, strconv.Itoa(server.Port)))
+		if err != nil {
+			return
+		}
+	}
+	connectOptions(&opts, server.Connect)
+	switch {
+	case server.Jump != "":
+		var jump *protocol.Server
+		jump, err = p.jumpServer(server.Jump)
+		if err != nil {
+			return
+		}
+		var jumpOpts infra.SSHOptions
+		jumpOpts, err = p.sshOptions(jump, hops+1)
+		if err != nil {
+			return
+		}
+		// the proxy of the server leads to its jump server
+		if jumpOpts.Jump == nil && jumpOpts.ProxyCommand == "" && jumpOpts.Proxy == "" {
+			jumpOpts.Proxy = server.Proxy
+		}
+		opts.Jump = &jumpOpts
+	case server.Proxy != "":
+		opts.Proxy = server.Proxy
+	case host != nil && host.ProxyJump != "":
+		// a,b reaches b through a, then the server through b
+		for _, hop := range strings.Split(host.ProxyJump, ",") {
+			var jump *protocol.Server
+			jump, err = jumpServer(hop, server)
+			if err != nil {
+				return
+			}
+			var jumpOpts infra.SSHOptions
+			jumpOpts, err = p.sshOptions(jump, hops+1)
+			if err != nil {
+				return
+			}
+			if opts.Jump != nil {
+				jumpOpts.Jump, jumpOpts.ProxyCommand, jumpOpts.Proxy = opts.Jump, "", ""
+			}
+			opts.Jump = &jumpOpts
+		}
+	case host != nil && host.ProxyCommand != "":
+		opts.ProxyCommand = host.ProxyCommand
+	}
+	return
+}
+
+// jumpServer returns the server named by a jump, interpolated
+// with the variables of the task when there is one.
+func (p *ServerRunner) jumpServer(name string) (*protocol.Server, error) {
+	var server *protocol.Server
+	if p.conf != nil {
+		server = p.conf.Servers[name]
+	}
+	if server == nil {
+		return nil, fmt.Errorf("jump server: '%s' not exists", name)
+	}
+	if p.task == nil {
+		return server, nil
+	}
+	server, err := server.Interpolate(p.task.lookupFor(p.task.task, p.task.params))
+	if err != nil {
+		return nil, fmt.Errorf("jump server: %s %s", name, strings.TrimPrefix(err.Error(), "server."))
 	}
 	return server, nil
 }
 
+// Ping connects to the server and checks it runs commands.
+func (p *ServerRunner) Ping() error {
+	conn, err := p.connect()
+	if err != nil {
+		return err
+	}
+	return conn.Ping()
+}
+
 func (p *ServerRunner) prepareTargetDir(target string) (dir string, err error) {
-	server, err := p.newExecServer()
+	server, err := p.connect()
 	if err != nil {
 		return
 	}
@@ -82,30 +225,47 @@ func (p *ServerRunner) prepareTargetDir(target string) (dir string, err error) {
 			}
 		}
 	}()
-	dir = target
+	dir = resolveTargetDir(target)
+	return
+}
+
+// bundleNameOf returns the bundle name recorded in snapshots for a source.
+func bundleNameOf(source string) string {
+	return fmt.Sprintf("%s.tar.gz", path.Base(source))
+}
+
+// resolveTargetDir returns the remote directory a mapper target deploys
+// into: the target itself with a trailing slash, otherwise its parent.
+func resolveTargetDir(target string) string {
 	if !strings.HasSuffix(target, "/") {
-		dir = filepath.Join(target, "../")
-		return
+		return filepath.Join(target, "../")
 	}
-	return
+	return target
 }
 
 func (p *ServerRunner) checkTargetPath(target string) (err error) {
-	if !strings.HasPrefix(target, "/") && strings.HasPrefix(target, "~") {
-		err = fmt.Errorf("invilad target path: '%s'", target)
-		return
+	return protocol.CheckTargetPath(target)
+}
+
+// DeployMapper deploys a mapper, incrementally if its mode is sync,
+// holding the deploy lock of its target directory.
+func (p *ServerRunner) DeployMapper(mapper *protocol.Mapper) error {
+	if mapper.Mode == protocol.MapperModeSync && mapper.Layout == protocol.MapperLayoutReleases {
+		return fmt.Errorf("mapper: %s mode: sync can't be used with layout: releases", mapper.Source)
 	}
-	if strings.HasPrefix(target, "/") {
-		items := strings.Split(strings.TrimPrefix(target, "/"), "/")
-		if len(items) < 2 {
-			err = fmt.Errorf("target path: %s too sort", target)
-			return
-		}
+	if err := p.checkTargetPath(mapper.Target); err != nil {
+		return err
 	}
-	return
+	return p.withLock(resolveTargetDir(mapper.Target), func() error {
+		if mapper.Mode == protocol.MapperModeSync {
+			return p.Sync(mapper)
+		}
+		return p.Upload(mapper)
+	})
 }
 
-func (p *ServerRunner) Upload(source, target string) (err error) {
+func (p *ServerRunner) Upload(mapper *protocol.Mapper) (err error) {
+	source, target := p.conf.Resolve(mapper.Source), mapper.Target
 
 	err = p.checkTargetPath(target)
 	if err != nil {
@@ -134,111 +294,143 @@ func (p *ServerRunner) Upload(source, target string) (err error) {
 	} else {
 		targetName = path.Base(target)
 	}
-	bundleName := fmt.Sprintf("%s.tar.gz", sourceName)
-	bundleLocalPath := fmt.Sprintf("%s/%s", CastTmpDir(), bundleName)
-	defer func() {
-		cleanCastTempDir()
-	}()
-
+	b, err := p.task.opts.Bundles.get(source)
+	if err != nil {
+		return
+	}
+	bundleName, bundleHash := b.name, b.hash
 	bundleRemoteTmpName := fmt.Sprintf("bundle-%s~", bundleName)
 	bundleRemoteTmpPath := fmt.Sprintf("%s/%s", targetDir, bundleRemoteTmpName)
 
-
-	// compress source using Go tar (cross-platform compatible)
-	sourceFile, err := os.Open(source)
+	server, err := p.connect()
 	if err != nil {
-		err = fmt.Errorf("open source error: %s", err)
 		return
 	}
+	lang := config.Load().Lang
+	remoteFS, remoteExec, snapshotRepo := p.remote(server)
+	prompter := &lockedPrompter{prompter: infra.NewStdinPrompter()}
+	deploySvc := application.NewDeployService(remoteFS, remoteExec, snapshotRepo, prompter, lang)
+	deploySvc.SetOutput(p.out)
+
+	// skip the transfer when this exact bundle is already deployed
+	if !p.task.opts.Force {
+		var unchanged bool
+		unchanged, err = deploySvc.Unchanged(targetDir, bundleName, bundleHash)
+		if err != nil {
+			return
+		}
+		if unchanged {
+			p.printUnchanged(mapper.Source, targetDir, targetName)
+			return
+		}
+	}
+
+	p.printUpload(mapper.Source, targetDir, targetName)
 	defer func() {
-		_ = sourceFile.Close()
+		_ = server.SFTPClient().Remove(bundleRemoteTmpPath)
 	}()
-
-	err = _tar.Compress([]*os.File{sourceFile}, bundleLocalPath)
+	err = p.stream(server, bundleRemoteTmpPath, b.size, b.writeTo)
 	if err != nil {
-		err = fmt.Errorf("compress source error: %s", err)
 		return
 	}
 
-	// compute local bundle hash
-	bundleData, err := os.ReadFile(bundleLocalPath)
+	// === Deploy via DDD Service ===
+	if mapper.Layout == protocol.MapperLayoutReleases {
+		err = deploySvc.DeployRelease(bundleRemoteTmpPath, targetDir, bundleName, bundleHash, mapper.Keep)
+	} else {
+		err = deploySvc.Deploy(bundleRemoteTmpPath, targetDir, bundleName, bundleHash, mapper.Keep)
+	}
 	if err != nil {
-		err = fmt.Errorf("read local bundle error: %s", err)
 		return
 	}
-	bundleHashSum := sha256.Sum256(bundleData)
-	bundleHash := fmt.Sprintf("%x", bundleHashSum[:])
 
-	// upload
-	bundleLocalFile, err := os.Open(bundleLocalPath)
+	return
+}
+
+// stream writes the output of write into a remote file, printing progress.
+// total is the expected size, -1 if unknown.
+func (p *ServerRunner) stream(server *infra.SSHConn, remotePath string, total int64, write func(w io.Writer) error) (err error) {
+	remoteFile, err := server.SFTPClient().Create(remotePath)
 	if err != nil {
-		err = fmt.Errorf("open local bundle error: %s", err)
+		err = fmt.Errorf("create remote bundle error: %s", err)
 		return
 	}
 	defer func() {
-		_ = bundleLocalFile.Close()
+		_ = remoteFile.Close()
 	}()
-	bundleLocalInfo, err := bundleLocalFile.Stat()
-	if err != nil {
-		err = fmt.Errorf("stat local bundle error: %s", err)
+	progress := &progressWriter{w: remoteFile, out: p.out, inplace: p.interactive(), total: total}
+	buffered := bufio.NewWriterSize(progress, uploadBufferSize)
+	if err = write(buffered); err != nil {
 		return
 	}
-
-	server, err := p.newExecServer()
-	if err != nil {
+	if err = buffered.Flush(); err != nil {
+		err = fmt.Errorf("uplaod write remote bundle error:%s", err)
 		return
 	}
-	bundleRemoteTmpFile, err := server.SFTPClient().Create(bundleRemoteTmpPath)
+	progress.Done()
+	return
+}
+
+// Rollback restores the previous deploy of target, or the snapshot entry `to`.
+// Rollback restores the entry `to` of target, the previous deploy by
+// default. It reports false when `to` is a deploy of another mapper
+// sharing the target directory, which is left untouched.
+func (p *ServerRunner) Rollback(target *Target, to string) (rolledBack bool, err error) {
+	server, err := p.connect()
 	if err != nil {
-		err = fmt.Errorf("create remote bundle error: %s", err)
 		return
 	}
-	defer func() {
-		_ = bundleRemoteTmpFile.Close()
-		_ = server.SFTPClient().Remove(bundleRemoteTmpPath)
-	}()
-
-	// print upload progress
-	size := 1 * 1024 * 1024
-	buf := make([]byte, 1024*1024)
-	total := unit.ByteSize(bundleLocalInfo.Size())
-	uploaded := int64(0)
-	p.printUpload(source, targetDir, targetName)
-	for {
-		n, _ := bundleLocalFile.Read(buf)
-		if n == 0 {
-			break
-		}
-		uploaded += int64(n)
-		if n < size {
-			_, err = bundleRemoteTmpFile.Write(buf[0:n])
-		} else {
-			_, err = bundleRemoteTmpFile.Write(buf)
+	p.printRollback(target)
+	remoteFS, remoteExec, snapshotRepo := p.remote(server)
+	rollbackSvc := application.NewRollbackService(remoteFS, remoteExec, snapshotRepo, config.Load().Lang)
+	rollbackSvc.SetOutput(p.out)
+	err = p.withLock(target.Dir, func() error {
+		_, rollbackErr := rollbackSvc.Rollback(target.Dir, target.BundleName, to)
+		if errors.Is(rollbackErr, application.ErrOtherBundle) {
+			_, _ = fmt.Fprintf(p.out, "  ⏭  %s\n", rollbackErr)
+			return nil
 		}
-		if err != nil {
-			err = fmt.Errorf("uplaod write remote bundle error:%s", err)
-			return
-		}
-		fmt.Printf("\rTotal: %s Uploaded: %s", total, unit.ByteSize(uploaded))
-	}
-	fmt.Printf("\n")
-
-	// === Deploy via DDD Service ===
-	cfg := config.Load()
-	lang := cfg.Lang
+		rolledBack = rollbackErr == nil
+		return rollbackErr
+	})
+	return
+}
 
-	remoteFS := infra.NewSSHRemoteFS(server)
-	remoteExec := infra.NewSSHRemoteExec(server)
-	snapshotRepo := infra.NewSnapshotRepo(remoteFS)
-	prompter := infra.NewStdinPrompter()
-	deploySvc := application.NewDeployService(remoteFS, remoteExec, snapshotRepo, prompter, lang)
+// Verify compares the files of target on the server with its latest snapshot entry.
+func (p *ServerRunner) Verify(target *Target) (*domain.DriftReport, error) {
+	server, err := p.connect()
+	if err != nil {
+		return nil, err
+	}
+	remoteFS, _, snapshotRepo := p.remote(server)
+	return application.NewVerifyService(remoteFS, snapshotRepo).Verify(target.Dir, target.BundleName)
+}
 
-	err = deploySvc.Deploy(bundleRemoteTmpPath, targetDir, bundleName, bundleHash)
+// ReadSnapshot reads the deploy snapshot of a target directory,
+// nil if nothing was deployed there yet.
+func (p *ServerRunner) ReadSnapshot(dir string) (*domain.Snapshot, error) {
+	server, err := p.connect()
 	if err != nil {
-		return
+		return nil, err
 	}
+	_, _, snapshotRepo := p.remote(server)
+	return snapshotRepo.Read(dir)
+}
 
-	return
+// remote wraps a connected server into the deploy domain adapters.
+func (p *ServerRunner) remote(server *infra.SSHConn) (domain.RemoteFS, domain.RemoteExec, domain.SnapshotRepository) {
+	remoteFS := infra.NewSSHRemoteFS(server)
+	return remoteFS, infra.NewSSHRemoteExec(server), infra.NewSnapshotRepo(remoteFS)
+}
+
+func (p *ServerRunner) printRollback(target *Target) {
+	logger.Step(
+		p.task.key,
+		p.task.task.Comment,
+		"⏪",
+		_color.New(_color.FgCyan).Sprintf("[%s]", p.server.Name()),
+		_color.New(_color.FgMagenta, _color.Bold).Sprintf("%s", target.Mapper.Target),
+	)
 }
 
 func (p *ServerRunner) printUpload(source, targetDir, targetName string) {
@@ -252,8 +444,20 @@ func (p *ServerRunner) printUpload(source, targetDir, targetName string) {
 	)
 }
 
+func (p *ServerRunner) printUnchanged(source, targetDir, targetName string) {
+	mapper := fmt.Sprintf("%s ===> %s", source, filepath.Join(targetDir, targetName))
+	logger.Step(
+		p.task.key,
+		p.task.task.Comment,
+		"⏭ ",
+		_color.New(_color.FgCyan).Sprintf("[%s]", p.server.Name()),
+		_color.New(_color.FgMagenta, _color.Bold).Sprintf("%s", mapper),
+		_color.New(_color.FgYellow).Sprint("unchanged"),
+	)
+}
+
 func (p *ServerRunner) CombinedExec(command string) error {
-	server, err := p.newExecServer()
+	server, err := p.connect()
 	if err != nil {
 		return err
 	}
@@ -261,27 +465,31 @@ func (p *ServerRunner) CombinedExec(command string) error {
 }
 
 func (p *ServerRunner) PipeExec(command string) error {
-	server, err := p.newExecServer()
+	return p.PipeExecInput(command, nil)
+}
+
+// PipeExecInput runs command like PipeExec, feeding stdin to it,
+// e.g. the password sudo reads.
+func (p *ServerRunner) PipeExecInput(command string, stdin io.Reader) error {
+	server, err := p.connect()
 	if err != nil {
 		return err
 	}
-	return server.PipeExec(command)
-}
-
-func GetCastTempDir() string {
-	return "./.cast/tmp"
-}
-
-func CastTmpDir() string {
-	dir := GetCastTempDir()
-	ok, err := _fs.Exists(dir)
-	if err == nil && !ok {
-		_ = _fs.MakeDir(dir)
+	stderr := p.out
+	if p.interactive() {
+		stderr = os.Stderr
 	}
-	return dir
+	return server.Run(command, stdin, p.out, stderr)
 }
 
-func cleanCastTempDir() {
-	_ = _fs.Remove(GetCastTempDir())
+// lockedPrompter serializes conflict prompts when several servers
+// deploy concurrently and share the terminal.
+type lockedPrompter struct {
+	prompter domain.UserPrompter
 }
 
+func (p *lockedPrompter) AskConflictAction(files []string, lang string) (domain.ConflictAction, string, error) {
+	stdoutLock.Lock()
+	defer stdoutLock.Unlock()
+	return p.prompter.AskConflictAction(files, lang)
+}
@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+
+	infra "github.com/koyeo/cast/deploy/infrastructure"
+	"github.com/koyeo/cast/protocol"
+)
+
+// maxJumps limits the jump servers of a connection, catching ProxyJump loops.
+const maxJumps = 8
+
+var (
+	sshConfigOnce sync.Once
+	sshConfig     *infra.SSHConfig
+	sshConfigErr  error
+)
+
+// loadSSHConfig returns the OpenSSH config of the user, read once per run.
+func loadSSHConfig() (*infra.SSHConfig, error) {
+	sshConfigOnce.Do(func() {
+		sshConfig, sshConfigErr = infra.LoadSSHConfig("~/.ssh/config", "/etc/ssh/ssh_config")
+	})
+	return sshConfig, sshConfigErr
+}
+
+// resolveSSHConfig returns a copy of server with the host, port, user and
+// identity file of its ssh_config alias filled in where it leaves them
+// empty, along with the resolved host, nil without ssh_config.
+func resolveSSHConfig(server *protocol.Server) (*protocol.Server, *infra.SSHHost, error) {
+	if server.SSHConfig == "" {
+		return server, nil, nil
+	}
+	config, err := loadSSHConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	host, err := config.Resolve(server.SSHConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	resolved := *server
+	if resolved.Host == "" {
+		resolved.Host = host.HostName
+	}
+	if resolved.Port == 0 {
+		resolved.Port = host.Port
+	}
+	if resolved.User == "" {
+		resolved.User = host.User
+	}
+	if resolved.User == "" {
+		if u, err := user.Current(); err == nil {
+			resolved.User = u.Username
+		}
+	}
+	if resolved.IdentityFile == "" {
+		// OpenSSH skips the identity files that don't exist
+		for _, file := range host.IdentityFiles {
+			if _, err = os.Stat(file); err == nil {
+				resolved.IdentityFile = file
+				break
+			}
+		}
+	}
+	return &resolved, host, nil
+}
+
+// jumpServer returns the server of a ProxyJump hop, [user@]host[:port],
+// itself resolved with ssh_config.
+func jumpServer(spec string, target *protocol.Server) (*protocol.Server, error) {
+	server := &protocol.Server{HostKeyPolicy: target.HostKeyPolicy}
+	hop := strings.TrimPrefix(strings.TrimSpace(spec), "ssh://")
+	if i := strings.LastIndex(hop, "@"); i >= 0 {
+		server.User, hop = hop[:i], hop[i+1:]
+	}
+	if host, port, err := net.SplitHostPort(hop); err == nil {
+		server.Port, err = strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ProxyJump port: %s", spec)
+		}
+		hop = host
+	}
+	if hop == "" {
+		return nil, fmt.Errorf("invalid ProxyJump: %s", spec)
+	}
+	server.SSHConfig = hop
+	return server, nil
+}
@@ -0,0 +1,156 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/gozelle/_color"
+	"github.com/koyeo/cast/config"
+	application "github.com/koyeo/cast/deploy/application"
+	infra "github.com/koyeo/cast/deploy/infrastructure"
+	"github.com/koyeo/cast/logger"
+	"github.com/koyeo/cast/protocol"
+	"github.com/koyeo/cast/utils/_tar"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Sync deploys a directory mapper incrementally: only files whose hash
+// differs from the remote manifest are transferred, and with mapper.Delete
+// remote files removed locally are deleted. The first sync is a full Upload.
+func (p *ServerRunner) Sync(mapper *protocol.Mapper) (err error) {
+	source, target := p.conf.Resolve(mapper.Source), mapper.Target
+	err = p.checkTargetPath(target)
+	if err != nil {
+		return
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		err = fmt.Errorf("upload source: %s not exists", source)
+		return
+	}
+	if !info.IsDir() {
+		err = fmt.Errorf("sync source: %s is not a directory", source)
+		return
+	}
+
+	targetDir, err := p.prepareTargetDir(target)
+	if err != nil {
+		return
+	}
+	sourceName := path.Base(source)
+	bundleName := bundleNameOf(source)
+
+	local, err := hashDir(source)
+	if err != nil {
+		return
+	}
+
+	server, err := p.connect()
+	if err != nil {
+		return
+	}
+	remoteFS, remoteExec, snapshotRepo := p.remote(server)
+	syncSvc := application.NewSyncService(remoteFS, remoteExec, snapshotRepo, infra.NewManifestRepo(remoteFS), config.Load().Lang)
+	syncSvc.SetOutput(p.out)
+
+	plan, manifest, err := syncSvc.Plan(targetDir, bundleName, local, mapper.Delete)
+	if err != nil {
+		return
+	}
+	if manifest == nil {
+		// never synced: deploy everything, then remember what was sent
+		err = p.Upload(mapper)
+		if err != nil {
+			return
+		}
+		return syncSvc.SaveManifest(targetDir, bundleName, local)
+	}
+	if p.task.opts.Force {
+		plan.Upload = plan.Upload[:0]
+		for name := range local {
+			plan.Upload = append(plan.Upload, name)
+		}
+	}
+	if plan.Empty() {
+		p.printUnchanged(mapper.Source, targetDir, sourceName)
+		return
+	}
+
+	p.printSync(mapper.Source, targetDir, len(plan.Upload), len(plan.Delete))
+	bundleRemotePath := ""
+	if len(plan.Upload) > 0 {
+		bundleRemotePath = fmt.Sprintf("%s/bundle-%s~", targetDir, bundleName)
+		defer func() {
+			_ = server.SFTPClient().Remove(bundleRemotePath)
+		}()
+		err = p.stream(server, bundleRemotePath, -1, func(w io.Writer) error {
+			if err := _tar.WriteFiles(w, filepath.Dir(source), plan.Upload); err != nil {
+				return fmt.Errorf("compress source error: %s", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	return syncSvc.Apply(bundleRemotePath, targetDir, bundleName, sourceName, local, plan)
+}
+
+// hashDir returns the sha256 of every regular file under dir, keyed by
+// slash separated path starting with the directory's own name.
+func hashDir(dir string) (map[string]string, error) {
+	hashes := map[string]string{}
+	base := path.Base(filepath.ToSlash(dir))
+	err := filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		hash, err := hashFile(filePath)
+		if err != nil {
+			return err
+		}
+		hashes[path.Join(base, filepath.ToSlash(rel))] = hash
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("hash source error: %s", err)
+	}
+	return hashes, nil
+}
+
+func hashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	h := sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func (p *ServerRunner) printSync(source, targetDir string, uploads, deletes int) {
+	mapper := fmt.Sprintf("%s ===> %s", source, filepath.Join(targetDir, path.Base(source)))
+	logger.Step(
+		p.task.key,
+		p.task.task.Comment,
+		"🔄",
+		_color.New(_color.FgCyan).Sprintf("[%s]", p.server.Name()),
+		_color.New(_color.FgMagenta, _color.Bold).Sprintf("%s", mapper),
+		fmt.Sprintf("+%d -%d", uploads, deletes),
+	)
+}
@@ -0,0 +1,72 @@
+package runner
+
+import (
+	"fmt"
+	"github.com/koyeo/cast/protocol"
+)
+
+// Target is a deploy destination of a task: one mapper on one server.
+type Target struct {
+	Key        string
+	Server     *protocol.Server
+	Mapper     *protocol.Mapper
+	Dir        string
+	BundleName string
+}
+
+// Targets resolves every mapper of the task's deploy steps, following
+// `use` steps, into the server directories it deploys to.
+func (p *TaskRunner) Targets() (targets []*Target, err error) {
+	err = p.collectTargets(p.key, p.args, map[string]bool{}, &targets)
+	return
+}
+
+func (p *TaskRunner) collectTargets(key string, args map[string]string, visited map[string]bool, targets *[]*Target) (err error) {
+	if visited[key] {
+		return
+	}
+	visited[key] = true
+	task, ok := p.conf.Tasks[key]
+	if !ok {
+		err = fmt.Errorf("use task: '%s' not found", key)
+		return
+	}
+	params, err := task.ResolveParams(args, p.conf.Vars)
+	if err != nil {
+		err = fmt.Errorf("task: %s %s", key, err)
+		return
+	}
+	for _, step := range task.Steps {
+		if step.Use != "" {
+			if err = p.collectTargets(step.Use, params, visited, targets); err != nil {
+				return
+			}
+			continue
+		}
+		if step.Deploy == nil {
+			continue
+		}
+		deploy, e := p.interpolate(step.Deploy, task, params)
+		if e != nil {
+			err = fmt.Errorf("task: %s %s", key, e)
+			return
+		}
+		keys, servers, e := p.resolveServers(deploy.Servers, p.lookupFor(task, params))
+		if e != nil {
+			err = e
+			return
+		}
+		for _, k := range keys {
+			for _, mapper := range deploy.Mappers {
+				*targets = append(*targets, &Target{
+					Key:        k,
+					Server:     servers[k],
+					Mapper:     mapper,
+					Dir:        resolveTargetDir(mapper.Target),
+					BundleName: bundleNameOf(mapper.Source),
+				})
+			}
+		}
+	}
+	return
+}
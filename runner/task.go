@@ -6,6 +6,12 @@ import (
 	"github.com/gozelle/_exec"
 	"github.com/koyeo/cast/logger"
 	"github.com/koyeo/cast/protocol"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 func NewTaskRunner(conf *protocol.Config, task *protocol.Task, key string) *TaskRunner {
@@ -17,6 +23,36 @@ type TaskRunner struct {
 	conf    *protocol.Config
 	task    *protocol.Task
 	parents map[string]bool
+	// args are the param values given to the task, params the resolved ones.
+	args   map[string]string
+	params map[string]string
+	opts   Options
+}
+
+// Options are run-wide flags, shared with the tasks pulled in by `use`.
+type Options struct {
+	// Force uploads bundles even if the same bundle is already deployed.
+	Force bool
+	// LockWait is how long to wait for a deploy lock held by someone else.
+	LockWait time.Duration
+	// DryRun prints the commands, uploads and remote executes of a task
+	// without running them.
+	DryRun bool
+	// IgnoreBranch skips the branches, require_clean and require_tag checks.
+	IgnoreBranch bool
+	// Bundles shares the compressed bundles between the tasks of a run.
+	// Without it, each task compresses its own.
+	Bundles *BundleCache
+}
+
+func (p *TaskRunner) SetOptions(opts Options) {
+	p.opts = opts
+}
+
+// SetArgs sets the param values passed as `task:name=value`,
+// taking priority over --set.
+func (p *TaskRunner) SetArgs(args map[string]string) {
+	p.args = args
 }
 
 func (p TaskRunner) prepareEnviron() []string {
@@ -28,13 +64,73 @@ func (p TaskRunner) prepareEnviron() []string {
 	for k, v := range p.task.Envs {
 		envs[k] = v
 	}
+	for k, v := range p.conf.Vars {
+		envs[k] = v
+	}
+	for k, v := range p.params {
+		envs[k] = v
+	}
 	for k, v := range envs {
 		environ = append(environ, fmt.Sprintf("%s=%s", k, v))
 	}
 	return environ
 }
 
+// lookupFor resolves the ${VAR} of a task's config: the variables
+// declared for it, see declaredFor, then the OS env.
+func (p TaskRunner) lookupFor(task *protocol.Task, params map[string]string) protocol.Lookup {
+	declared := p.declaredFor(task, params)
+	return func(name string) (string, bool) {
+		if v, ok := declared(name); ok {
+			return v, true
+		}
+		return os.LookupEnv(name)
+	}
+}
+
+// declaredFor resolves the variables declared for a task: its params
+// first, then values passed with --set, the task envs and the global envs.
+// Remote commands only expand these, leaving the others to the remote shell.
+func (p TaskRunner) declaredFor(task *protocol.Task, params map[string]string) protocol.Lookup {
+	return func(name string) (string, bool) {
+		if v, ok := params[name]; ok {
+			return v, true
+		}
+		if v, ok := p.conf.Vars[name]; ok {
+			return v, true
+		}
+		if v, ok := task.Envs[name]; ok {
+			return v, true
+		}
+		v, ok := p.conf.Envs[name]
+		return v, ok
+	}
+}
+
+// interpolate expands the variables of a deploy step of task, leaving
+// those of remote commands the config doesn't declare to the remote shell.
+func (p TaskRunner) interpolate(deploy *protocol.Deploy, task *protocol.Task, params map[string]string) (*protocol.Deploy, error) {
+	return deploy.Interpolate(p.lookupFor(task, params), p.declaredFor(task, params))
+}
+
 func (p TaskRunner) Exec() (err error) {
+	if p.parents == nil {
+		if err = p.preflight(p.key, p.task, p.args, map[string]bool{}); err != nil {
+			return
+		}
+		if p.opts.Bundles == nil {
+			p.opts.Bundles = NewBundleCache()
+			defer p.opts.Bundles.Remove()
+		}
+	}
+	p.params, err = p.task.ResolveParams(p.args, p.conf.Vars)
+	if err != nil {
+		err = fmt.Errorf("task: %s %s", p.key, err)
+		return
+	}
+	if p.opts.DryRun {
+		p.planEnvs()
+	}
 	for _, step := range p.task.Steps {
 		if step.Use != "" {
 			if err = p.use(step.Use); err != nil {
@@ -75,11 +171,16 @@ func (p TaskRunner) use(key string) (err error) {
 		return
 	}
 	taskRunner := NewTaskRunner(p.conf, task, key)
+	taskRunner.opts = p.opts
+	taskRunner.args = p.params
 
-	// store parent task key to avoid circle dependency
+	// store all ancestor task keys to avoid circle dependency
 	taskRunner.parents = map[string]bool{
 		p.key: true,
 	}
+	for k := range p.parents {
+		taskRunner.parents[k] = true
+	}
 	p.printExecUseStart(key, task.Comment)
 	err = taskRunner.Exec()
 	if err != nil {
@@ -88,55 +189,210 @@ func (p TaskRunner) use(key string) (err error) {
 	return
 }
 
+// preflight resolves the params of the task and of the tasks it uses,
+// passing each its parent's params, expands the variables of their deploy
+// steps and checks the git state of their workspaces, so that missing
+// params, undefined variables or a wrong branch fail before any step runs.
+func (p TaskRunner) preflight(key string, task *protocol.Task, args map[string]string, visited map[string]bool) (err error) {
+	if visited[key] {
+		return
+	}
+	visited[key] = true
+	params, err := task.ResolveParams(args, p.conf.Vars)
+	if err != nil {
+		err = fmt.Errorf("task: %s %s", key, err)
+		return
+	}
+	if !p.opts.IgnoreBranch {
+		var workspace string
+		workspace, err = p.workspaceOf(task, params)
+		if err != nil {
+			return
+		}
+		if err = checkGit(task, workspace); err != nil {
+			err = fmt.Errorf("task: %s %s", key, err)
+			return
+		}
+	}
+	for _, step := range task.Steps {
+		if step.Deploy != nil {
+			deploy, e := p.interpolate(step.Deploy, task, params)
+			if e != nil {
+				err = fmt.Errorf("task: %s %s", key, e)
+				return
+			}
+			if _, _, err = p.resolveServers(deploy.Servers, p.lookupFor(task, params)); err != nil {
+				return
+			}
+		}
+		if step.Use == "" {
+			continue
+		}
+		if used, ok := p.conf.Tasks[step.Use]; ok {
+			if err = p.preflight(step.Use, used, params, visited); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
 func (p *TaskRunner) deploy(deploy *protocol.Deploy) (err error) {
-	servers := map[string]*protocol.Server{}
-	runners := make([]*ServerRunner, 0)
-	defer func() {
-		for _, v := range runners {
-			v.Close()
+	deploy, err = p.interpolate(deploy, p.task, p.params)
+	if err != nil {
+		return
+	}
+	if p.opts.DryRun {
+		return p.planDeploy(deploy)
+	}
+	keys, servers, err := p.resolveServers(deploy.Servers, p.lookupFor(p.task, p.params))
+	if err != nil {
+		return
+	}
+	if deploy.Strategy != nil {
+		return p.deployRolling(deploy, keys, servers)
+	}
+	if deploy.Parallel > 1 {
+		return p.deployParallel(deploy, keys, servers)
+	}
+	runners := map[string]*ServerRunner{}
+	for _, key := range keys {
+		serverRunner := NewServerRunner(p.conf, p, servers[key], key)
+		runners[key] = serverRunner
+		for _, mapper := range deploy.Mappers {
+			err = serverRunner.DeployMapper(mapper)
+			if err != nil {
+				return
+			}
 		}
-	}()
-	for _, v := range deploy.Servers {
+	}
+	for _, key := range keys {
+		err = p.executeServer(runners[key], servers[key], deploy.Executes)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// resolveServers resolves deploy servers, including `use` references,
+// into a host keyed map, returning the keys in a stable order.
+func (p *TaskRunner) resolveServers(items []*protocol.Server, lookup protocol.Lookup) (keys []string, servers map[string]*protocol.Server, err error) {
+	servers = map[string]*protocol.Server{}
+	for _, v := range items {
 		if v.Use != "" {
 			server, ok := p.conf.Servers[v.Use]
 			if !ok {
 				err = fmt.Errorf("deploy use server: '%s' not exists", v.Use)
 				return
 			}
-			servers[server.Host] = server
-		} else {
-			servers[v.Host] = v
+			server, err = server.Interpolate(lookup)
+			if err != nil {
+				err = fmt.Errorf("server: %s %s", v.Use, strings.TrimPrefix(err.Error(), "server."))
+				return
+			}
+			v = server
+		}
+		var resolved *protocol.Server
+		resolved, _, err = resolveSSHConfig(v)
+		if err != nil {
+			return
 		}
+		servers[resolved.Host] = resolved
 	}
 	for key, server := range servers {
 		if server.Host == "" {
 			err = fmt.Errorf("deploy server host is empty")
 			return
 		}
-		serverRunner := NewServerRunner(p.conf, p, server, key)
-		runners = append(runners, serverRunner)
-		for _, mapper := range deploy.Mappers {
-			err = serverRunner.Upload(mapper.Source, mapper.Target)
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// deployParallel uploads and executes on up to deploy.Parallel servers at
+// once, prefixing each server's output and printing a summary at the end.
+func (p *TaskRunner) deployParallel(deploy *protocol.Deploy, keys []string, servers map[string]*protocol.Server) (err error) {
+	results := p.deployServers(deploy, keys, servers, deploy.Parallel)
+	failed := 0
+	for i, key := range keys {
+		if results[i] != nil {
+			failed++
+		}
+		p.printServerResult(servers[key], results[i])
+	}
+	if failed > 0 {
+		err = fmt.Errorf("deploy failed on %d/%d servers", failed, len(keys))
+		return
+	}
+	return
+}
+
+// deployServers runs deployServer on the given servers with at most
+// concurrency servers in flight, returning one result per key.
+func (p *TaskRunner) deployServers(deploy *protocol.Deploy, keys []string, servers map[string]*protocol.Server, concurrency int) []error {
+	return runConcurrently(keys, concurrency, func(key string) error {
+		return p.deployServer(deploy, key, servers[key])
+	})
+}
+
+// runConcurrently calls fn for every key, with at most concurrency calls
+// in flight, and returns the results in the order of keys. A failure
+// doesn't stop the other keys.
+func runConcurrently(keys []string, concurrency int, fn func(key string) error) []error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make([]error, len(keys))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = fn(key)
+		}(i, key)
+	}
+	wg.Wait()
+	return results
+}
+
+// deployServer runs all mappers and executes of a deploy on one server.
+func (p *TaskRunner) deployServer(deploy *protocol.Deploy, key string, server *protocol.Server) (err error) {
+	out := newPrefixWriter(os.Stdout, _color.New(_color.FgCyan).Sprintf("[%s] ", server.Name()))
+	defer out.Flush()
+	serverRunner := NewServerRunner(p.conf, p, server, key)
+	serverRunner.SetOutput(out)
+	for _, mapper := range deploy.Mappers {
+		err = serverRunner.DeployMapper(mapper)
+		if err != nil {
+			return
+		}
+	}
+	return p.executeServer(serverRunner, server, deploy.Executes)
+}
+
+func (p *TaskRunner) executeServer(serverRunner *ServerRunner, server *protocol.Server, executes []*protocol.Execute) (err error) {
+	for _, execute := range executes {
+		if execute.Run != "" {
+			p.printServerExec(server, describeExecute(execute))
+			var command string
+			var stdin io.Reader
+			command, stdin, err = p.remoteCommand(server, execute)
 			if err != nil {
 				return
 			}
-		}
-	}
-	for key, server := range servers {
-		serverRunner := NewServerRunner(p.conf, p, server, key)
-		runners = append(runners, serverRunner)
-		for _, execute := range deploy.Executes {
-			if execute.Run != "" {
-				p.printServerExec(server, execute.Run)
-				err = serverRunner.PipeExec(execute.Run)
-				if err != nil {
-					err = fmt.Errorf("server execute error: %s", err)
-					return
-				}
+			err = serverRunner.PipeExecInput(command, stdin)
+			if err != nil {
+				err = fmt.Errorf("server execute error: %s", err)
+				return
 			}
 		}
 	}
-
 	return
 }
 
@@ -144,13 +400,27 @@ func (p TaskRunner) execute(step *protocol.Step) (err error) {
 	if step.Run == "" {
 		return
 	}
+	workspace, err := p.workspaceOf(p.task, p.params)
+	if err != nil {
+		return
+	}
+	if p.opts.DryRun {
+		if workspace != "" {
+			p.printExec(fmt.Sprintf("cd %s && %s", workspace, step.Run))
+		} else {
+			p.printExec(step.Run)
+		}
+		return
+	}
 	p.printExec(step.Run)
+	command := step.Run
+	if workspace != "" {
+		// _exec.Runner.SetDir sets the shell instead of the directory
+		command = fmt.Sprintf("cd %s && %s", shellQuote(workspace), command)
+	}
 	runner := _exec.NewRunner()
-	runner.AddCommand(step.Run)
+	runner.AddCommand(command)
 	runner.SetEnviron(p.prepareEnviron())
-	if p.task.Workspace != "" {
-		runner.SetDir(p.task.Workspace)
-	}
 	err = runner.PipeOutput()
 	if err != nil {
 		err = fmt.Errorf("runner pipe exec error: %s", err)
@@ -159,6 +429,15 @@ func (p TaskRunner) execute(step *protocol.Step) (err error) {
 	return
 }
 
+// workspaceOf returns the local directory the commands of task run in.
+func (p TaskRunner) workspaceOf(task *protocol.Task, params map[string]string) (string, error) {
+	workspace, err := protocol.Expand(task.Workspace, p.lookupFor(task, params))
+	if err != nil {
+		return "", fmt.Errorf("workspace: %s", err)
+	}
+	return p.conf.Resolve(workspace), nil
+}
+
 func (p TaskRunner) PrintStart() {
 	logger.Step(p.key, p.task.Comment, "🕘", "start")
 }
@@ -192,6 +471,36 @@ func (p TaskRunner) printServerExec(server *protocol.Server, command string) {
 	)
 }
 
+func (p TaskRunner) printServerResult(server *protocol.Server, err error) {
+	if err != nil {
+		logger.Step(
+			p.key,
+			p.task.Comment,
+			"❌️",
+			_color.New(_color.FgCyan).Sprintf("[%s]", server.Name()),
+			_color.New(_color.FgHiRed).Sprintf("%s", err),
+		)
+		return
+	}
+	logger.Step(
+		p.key,
+		p.task.Comment,
+		"✅",
+		_color.New(_color.FgCyan).Sprintf("[%s]", server.Name()),
+		_color.New(_color.FgHiGreen).Sprint("success"),
+	)
+}
+
+func (p TaskRunner) printServerSkipped(server *protocol.Server) {
+	logger.Step(
+		p.key,
+		p.task.Comment,
+		"⏭ ",
+		_color.New(_color.FgCyan).Sprintf("[%s]", server.Name()),
+		_color.New(_color.FgYellow).Sprint("skipped"),
+	)
+}
+
 func (p TaskRunner) printExec(command string) {
 	logger.Step(
 		p.key,
@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koyeo/cast/protocol"
+)
+
+func TestRunConcurrently(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e"}
+	var mu sync.Mutex
+	running, peak := 0, 0
+	called := map[string]bool{}
+	results := runConcurrently(keys, 2, func(key string) error {
+		mu.Lock()
+		called[key] = true
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if key == "b" || key == "d" {
+			return fmt.Errorf("%s failed", key)
+		}
+		return nil
+	})
+	if peak > 2 {
+		t.Errorf("expected at most 2 servers at once, got %d", peak)
+	}
+	if len(called) != len(keys) {
+		t.Errorf("expected every server deployed despite failures, got %v", called)
+	}
+	for i, key := range keys {
+		failed := key == "b" || key == "d"
+		if failed != (results[i] != nil) {
+			t.Errorf("result of %s: %v", key, results[i])
+		}
+	}
+}
+
+func TestDeployParallel_Failures(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	defer CloseConnections()
+	task := &protocol.Task{}
+	p := NewTaskRunner(&protocol.Config{}, task, "deploy")
+	// a port nothing listens on
+	unreachable := func() *protocol.Server {
+		return &protocol.Server{Host: "127.0.0.1", Port: 1, User: "deploy", Connect: &protocol.Connect{Timeout: time.Second}}
+	}
+	servers := map[string]*protocol.Server{"a": unreachable(), "b": unreachable(), "c": {Host: "10.0.0.3", Jump: "missing"}}
+	deploy := &protocol.Deploy{Parallel: 3, Executes: []*protocol.Execute{{Run: "true"}}}
+	err := p.deployParallel(deploy, []string{"a", "b", "c"}, servers)
+	if err == nil || err.Error() != "deploy failed on 3/3 servers" {
+		t.Fatalf("expected every failure counted, got %v", err)
+	}
+
+	// servers with nothing to do succeed without connecting
+	err = p.deployParallel(&protocol.Deploy{Parallel: 2}, []string{"a", "b"}, servers)
+	if err != nil {
+		t.Errorf("expected success, got %v", err)
+	}
+}
+
+func TestExec_UndefinedVariable(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "marker")
+	conf := &protocol.Config{Dir: dir, Tasks: map[string]*protocol.Task{
+		"upload": {Steps: []*protocol.Step{{Deploy: &protocol.Deploy{
+			Servers: []*protocol.Server{{Host: "10.0.0.1"}},
+			Mappers: []*protocol.Mapper{{Source: "./dist", Target: "/srv/${MISSING}/"}},
+		}}}},
+	}}
+	// the deploy step is reached through `use`, after a local step
+	conf.Tasks["deploy"] = &protocol.Task{Steps: []*protocol.Step{{Run: "touch " + marker}, {Use: "upload"}}}
+	p := NewTaskRunner(conf, conf.Tasks["deploy"], "deploy")
+	p.SetOptions(Options{IgnoreBranch: true})
+	err := p.Exec()
+	if err == nil || !strings.Contains(err.Error(), "task: upload deploy.mappers[0].target: undefined variable: ${MISSING}") {
+		t.Fatalf("expected undefined variable error, got %v", err)
+	}
+	if _, err = os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("expected no step run, got %v", err)
+	}
+}
+
+func TestInterpolate_RemoteShellVariables(t *testing.T) {
+	t.Setenv("HOME", "/home/local")
+	t.Setenv("LOCAL_ONLY", "local")
+	conf := &protocol.Config{Envs: map[string]string{"APP": "web"}, Vars: map[string]string{"VERSION": "1.2"}}
+	task := &protocol.Task{}
+	p := TaskRunner{conf: conf, task: task}
+	deploy := &protocol.Deploy{
+		Mappers:  []*protocol.Mapper{{Source: "./dist", Target: "/srv/${LOCAL_ONLY}/"}},
+		Executes: []*protocol.Execute{{Run: "cd ${HOME}/${APP} && ./run ${VERSION} ${REMOTE_ONLY} ${LOCAL_ONLY}"}},
+	}
+	expanded, err := p.interpolate(deploy, task, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "cd ${HOME}/web && ./run 1.2 ${REMOTE_ONLY} ${LOCAL_ONLY}"; expanded.Executes[0].Run != want {
+		t.Errorf("expected %q, got %q", want, expanded.Executes[0].Run)
+	}
+	if expanded.Mappers[0].Target != "/srv/local/" {
+		t.Errorf("expected the mapper target expanded with the local env, got %s", expanded.Mappers[0].Target)
+	}
+}
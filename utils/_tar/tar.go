@@ -5,63 +5,99 @@ import (
 	"compress/gzip"
 	"io"
 	"os"
+	"path/filepath"
 )
 
 func Compress(files []*os.File, dest string) error {
-	d, _ := os.Create(dest)
+	d, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
 	defer d.Close()
-	gw := gzip.NewWriter(d)
-	defer gw.Close()
-	tw := tar.NewWriter(gw)
-	defer tw.Close()
+	paths := make([]string, 0, len(files))
 	for _, file := range files {
-		err := compress(file, "", tw)
+		paths = append(paths, file.Name())
+	}
+	return Write(d, paths...)
+}
+
+// Write streams a tar.gz of the given files or directories into w.
+// Directory entries are visited in name order, so unchanged sources
+// always produce the same bytes.
+func Write(w io.Writer, paths ...string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, p := range paths {
+		err := compress(p, "", tw)
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
 }
 
-func compress(file *os.File, prefix string, tw *tar.Writer) error {
-	info, err := file.Stat()
+// WriteFiles streams a tar.gz of the given files into w. Names are slash
+// separated paths relative to root and are kept as entry names.
+func WriteFiles(w io.Writer, root string, names []string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, name := range names {
+		err := compressFile(filepath.Join(root, filepath.FromSlash(name)), name, tw)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func compress(path, prefix string, tw *tar.Writer) error {
+	info, err := os.Stat(path)
 	if err != nil {
 		return err
 	}
 	if info.IsDir() {
 		prefix = prefix + "/" + info.Name()
-		var fileInfos []os.FileInfo
-		fileInfos, err = file.Readdir(-1)
+		var entries []os.DirEntry
+		entries, err = os.ReadDir(path)
 		if err != nil {
 			return err
 		}
-		for _, fi := range fileInfos {
-			var f *os.File
-			f, err = os.Open(file.Name() + "/" + fi.Name())
-			if err != nil {
-				return err
-			}
-			err = compress(f, prefix, tw)
+		for _, entry := range entries {
+			err = compress(filepath.Join(path, entry.Name()), prefix, tw)
 			if err != nil {
 				return err
 			}
 		}
-	} else {
-		var header *tar.Header
-		header, err = tar.FileInfoHeader(info, "")
-		header.Name = prefix + "/" + header.Name
-		if err != nil {
-			return err
-		}
-		err = tw.WriteHeader(header)
-		if err != nil {
-			return err
-		}
-		_, err = io.Copy(tw, file)
-		file.Close()
-		if err != nil {
-			return err
-		}
+		return nil
+	}
+	return compressFile(path, prefix+"/"+info.Name(), tw)
+}
+
+func compressFile(path, name string, tw *tar.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	err = tw.WriteHeader(header)
+	if err != nil {
+		return err
 	}
-	return nil
+	_, err = io.Copy(tw, file)
+	return err
 }